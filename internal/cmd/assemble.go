@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/mtardy/mahebpf/pkg/asm"
+)
+
+const asmUsage = `Usage: dbpf asm file
+
+Assemble a program written in the disassembler syntax into the ASCII format,
+for the byte order given with --endian`
+
+func assemble(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, asmUsage)
+		os.Exit(2)
+	}
+
+	input, err := readInput(args[0])
+	if err != nil {
+		fatal(err)
+	}
+	order, err := byteOrder(endianOption)
+	if err != nil {
+		fatal(err)
+	}
+	prog, err := asm.ParseWithByteOrder(bytes.NewReader(input), order)
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := prog.WriteASCII(os.Stdout); err != nil {
+		fatal(err)
+	}
+}
@@ -1,14 +1,22 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/mtardy/mahebpf/pkg/cmacro"
+	"github.com/mtardy/mahebpf/pkg/helper"
+	"github.com/mtardy/mahebpf/pkg/instruction"
 	"github.com/mtardy/mahebpf/pkg/program"
 )
 
@@ -16,18 +24,40 @@ var (
 	fileTypeOption string
 	bytesOption    bool
 	numberOption   bool
+	tolerantOption bool
+	helpersOption  string
+	funcOption     string
+	allOption      bool
+	endianOption   string
+	formatOption   string
+	syntaxOption   string
+	labelsOption   bool
+	arrowsOption   bool
 )
 
 const usage = `Usage: dbpf [flags] file [section]
+       dbpf asm file
+       dbpf info file
 
-An educational eBPF disassembler
+An educational eBPF disassembler, use - as file to read the standard input.
+A file named asm or info in the current directory is disassembled rather than
+running the command.
 
 Flags:`
 
 func init() {
-	flag.StringVar(&fileTypeOption, "type", "elf", "type of the file to disassemble (elf or ascii)")
+	flag.StringVar(&fileTypeOption, "type", "elf", "type of the file to disassemble (elf, ascii, raw or c for struct bpf_insn macros)")
 	flag.BoolVar(&bytesOption, "bytes", true, "print instruction bytes")
 	flag.BoolVar(&numberOption, "number", true, "print line number")
+	flag.BoolVar(&tolerantOption, "tolerant", false, "print undecodable instructions as raw data and keep going")
+	flag.StringVar(&endianOption, "endian", "little", "byte order of the instructions of ascii and raw files and of the assembled programs (little or big), ELF files use the one of their header")
+	flag.StringVar(&formatOption, "format", "text", "output format (text, json or c for struct bpf_insn macros)")
+	flag.StringVar(&syntaxOption, "syntax", "llvm", "syntax of the disassembled instructions (llvm for llvm-objdump, bpftool for bpftool prog dump xlated or ubpf)")
+	flag.BoolVar(&labelsOption, "labels", true, "name the targets of the jumps and local calls and print a label line before them")
+	flag.BoolVar(&arrowsOption, "arrows", false, "draw the jumps as ASCII arrows on the left of the instructions")
+	flag.BoolVar(&allOption, "all", false, "disassemble all the program sections of the ELF, the default without section")
+	flag.StringVar(&funcOption, "func", "", "only disassemble the function of the ELF section with this name")
+	flag.StringVar(&helpersOption, "helpers", "", "JSON file of the helper table naming the helper calls, replaces the default one")
 }
 
 func fatal(err error) {
@@ -35,21 +65,153 @@ func fatal(err error) {
 	os.Exit(1)
 }
 
-func printDisassembled(disassembled []program.DisassembledProgram) {
-	width := len(strconv.Itoa(disassembled[len(disassembled)-1].InsNumber))
-	for _, ins := range disassembled {
+// printDisassembled prints the instructions of the function, the line numbers
+// are padded to width. The jumps and local calls are followed by the label of
+// their target, and the targets preceded by a label line unless they start the
+// function of the same name. The jumps are drawn as arrows in a gutter on the
+// left with --arrows.
+func printDisassembled(disassembled []program.DisassembledProgram, fn string, labels map[int]string, width int) {
+	var arrows program.Arrows
+	if arrowsOption {
+		arrows = program.NewArrows(disassembled)
+	}
+	for i, ins := range disassembled {
+		if label, ok := labels[ins.InsNumber]; ok && label != fn {
+			fmt.Println(arrows.Above(i) + label + ":")
+		}
 		out := strings.Builder{}
+		out.WriteString(arrows.Line(i))
 		if numberOption {
 			out.WriteString(fmt.Sprintf("%*d: ", width, ins.InsNumber))
 		}
 		if bytesOption {
 			out.WriteString(ins.Instruction.String() + " ")
 		}
-		out.WriteString(ins.Instruction.Disassemble())
+		out.WriteString(ins.Disassembled)
+		if target, ok := ins.Target(); ok {
+			if label, ok := labels[target]; ok {
+				out.WriteString(" <" + label + ">")
+			}
+		}
 		fmt.Println(out.String())
 	}
 }
 
+// printHeader prints a section or function header, as a comment for the C
+// output
+func printHeader(header string) {
+	if formatOption == "c" {
+		header = "/* " + header + " */"
+	}
+	fmt.Println(header)
+}
+
+// jsonFunction is the JSON output of a function, the section and function
+// names are empty when unknown
+type jsonFunction struct {
+	Section      string                        `json:"section,omitempty"`
+	Function     string                        `json:"function,omitempty"`
+	Instructions []program.DisassembledProgram `json:"instructions"`
+}
+
+func printJSON(sections []program.ProgramSection) {
+	functions := []jsonFunction{}
+	for _, sec := range sections {
+		for _, fn := range sec.Functions {
+			functions = append(functions, jsonFunction{
+				Section:      sec.Name,
+				Function:     fn.Name,
+				Instructions: disassemble(fn.Program),
+			})
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(functions); err != nil {
+		fatal(err)
+	}
+}
+
+// numberWidth returns the width of the largest instruction number
+func numberWidth(sections []program.ProgramSection) int {
+	width := 1
+	for _, sec := range sections {
+		for _, fn := range sec.Functions {
+			if n := len(fn.Program.Instructions); n > 0 {
+				width = max(width, len(strconv.Itoa(fn.Program.Instructions[n-1].Number)))
+			}
+		}
+	}
+	return width
+}
+
+// findFunction returns the section holding only the function with the given
+// name
+func findFunction(sections []program.ProgramSection, name string) ([]program.ProgramSection, error) {
+	var all []program.Function
+	for _, sec := range sections {
+		if fn, err := program.FindFunction(sec.Functions, name); err == nil {
+			return []program.ProgramSection{{Name: sec.Name, Functions: []program.Function{fn}}}, nil
+		}
+		all = append(all, sec.Functions...)
+	}
+	_, err := program.FindFunction(all, name)
+	return nil, err
+}
+
+// readInput returns the content of the file at path, or of the standard input
+// for -
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func byteOrder(endian string) (binary.ByteOrder, error) {
+	switch strings.ToLower(endian) {
+	case "little", "le", "bpfel":
+		return binary.LittleEndian, nil
+	case "big", "be", "bpfeb":
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("invalid endian %q, the only byte orders available are little or big", endian)
+	}
+}
+
+func disassemble(prog *program.Program) []program.DisassembledProgram {
+	if tolerantOption {
+		disassembled, err := prog.DisassembleTolerant()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return disassembled
+	}
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		fatal(err)
+	}
+	return disassembled
+}
+
+// subcommand returns the command named by the first argument, an existing
+// file named like a command is disassembled instead
+func subcommand(arg string) (func(args []string), bool) {
+	var command func(args []string)
+	switch arg {
+	case "asm":
+		command = assemble
+	case "info":
+		command = info
+	default:
+		return nil, false
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return nil, false
+	}
+	return command, true
+}
+
 func Execute() {
 	flag.Parse()
 
@@ -67,31 +229,135 @@ func Execute() {
 		os.Exit(2)
 	}
 
-	var prog *program.Program
-	var err error
+	if command, ok := subcommand(flag.Arg(0)); ok {
+		command(flag.Args()[1:])
+		return
+	}
+
+	switch formatOption {
+	case "text", "json", "c":
+	default:
+		fatal(fmt.Errorf("invalid format %q, the only formats available are text, json or c", formatOption))
+	}
+
+	syntax, ok := instruction.SyntaxByName(strings.ToLower(syntaxOption))
+	if !ok {
+		fatal(fmt.Errorf("invalid syntax %q, the only syntaxes available are llvm, bpftool or ubpf", syntaxOption))
+	}
+
+	input, err := readInput(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+
+	var sections []program.ProgramSection
+	// sectionHeaders is set when disassembling all the program sections
+	var sectionHeaders bool
 	switch strings.ToLower(fileTypeOption) {
 	case "elf":
-		if len(flag.Args()) < 2 {
-			sections, err := program.ListELFSections(flag.Arg(0))
+		if allOption || len(flag.Args()) < 2 {
+			sections, err = program.LoadProgramSections(bytes.NewReader(input))
 			if err != nil {
 				fatal(err)
 			}
-			fatal(fmt.Errorf("please provide an ELF section to disassemble, available sections: %v", sections))
+			if len(sections) == 0 {
+				fatal(errors.New("no program section found, please provide an ELF section to disassemble"))
+			}
+			sectionHeaders = true
+			break
 		}
-		prog, err = program.FromELF(flag.Arg(0), flag.Arg(1))
+		functions, err := program.LoadFunctions(bytes.NewReader(input), flag.Arg(1))
 		if err != nil {
 			fatal(err)
 		}
-	case "ascii":
-		prog, err = program.FromASCII(flag.Arg(0))
+		sections = []program.ProgramSection{{Name: flag.Arg(1), Functions: functions}}
+	case "ascii", "raw":
+		order, err := byteOrder(endianOption)
 		if err != nil {
 			fatal(err)
 		}
+		read := program.ReadASCII
+		if strings.ToLower(fileTypeOption) == "raw" {
+			read = program.ReadRaw
+		}
+		prog, err := read(bytes.NewReader(input), order)
+		if err != nil {
+			fatal(err)
+		}
+		sections = []program.ProgramSection{{Functions: []program.Function{{Program: prog}}}}
+	case "c":
+		prog, err := cmacro.Parse(bytes.NewReader(input))
+		if err != nil {
+			fatal(err)
+		}
+		sections = []program.ProgramSection{{Functions: []program.Function{{Program: prog}}}}
 	default:
-		fatal(fmt.Errorf("invalid type %q, the only type available are elf or ascii", fileTypeOption))
+		fatal(fmt.Errorf("invalid type %q, the only type available are elf, ascii, raw or c", fileTypeOption))
 	}
 
-	if prog != nil {
-		printDisassembled(prog.Disassemble())
+	// the labels are named before selecting a function, for its calls to be
+	// named after the functions they land on
+	labels := map[string]map[int]string{}
+	if labelsOption {
+		for _, sec := range sections {
+			labels[sec.Name] = program.Labels(sec.Functions)
+		}
+	}
+
+	if funcOption != "" {
+		var err error
+		sections, err = findFunction(sections, funcOption)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	var helpers *helper.Table
+	if helpersOption != "" {
+		var err error
+		helpers, err = helper.FromFile(helpersOption)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	for _, sec := range sections {
+		for _, fn := range sec.Functions {
+			if helpers != nil {
+				fn.Program.Helpers = helpers
+			}
+			fn.Program.Syntax = syntax
+		}
+	}
+
+	if formatOption == "json" {
+		printJSON(sections)
+		return
+	}
+
+	width := numberWidth(sections)
+	for i, sec := range sections {
+		if i > 0 {
+			fmt.Println()
+		}
+		if sectionHeaders {
+			printHeader(fmt.Sprintf("Disassembly of section %s:", sec.Name))
+			fmt.Println()
+		}
+		for j, fn := range sec.Functions {
+			if j > 0 {
+				fmt.Println()
+			}
+			if fn.Name != "" {
+				printHeader(fmt.Sprintf("<%s>:", fn.Name))
+			}
+			if formatOption == "c" {
+				if err := cmacro.Write(os.Stdout, *fn.Program); err != nil {
+					fatal(err)
+				}
+				continue
+			}
+			printDisassembled(disassemble(fn.Program), fn.Name, labels[sec.Name], width)
+		}
 	}
 }
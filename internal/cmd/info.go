@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/mtardy/mahebpf/pkg/program"
+)
+
+const infoUsage = `Usage: dbpf info file
+
+Summarize the programs, maps and globals of a BPF ELF object`
+
+func info(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, infoUsage)
+		os.Exit(2)
+	}
+
+	input, err := readInput(args[0])
+	if err != nil {
+		fatal(err)
+	}
+	obj, err := program.LoadObject(bytes.NewReader(input))
+	if err != nil {
+		fatal(err)
+	}
+
+	if obj.License != "" {
+		fmt.Printf("license: %s\n", obj.License)
+	}
+	if v := obj.KernelVersion; v != 0 {
+		// encoded with the KERNEL_VERSION macro
+		fmt.Printf("version: %d (%d.%d.%d)\n", v, v>>16, v>>8&0xff, v&0xff)
+	}
+
+	if len(obj.Programs) > 0 {
+		fmt.Println("programs:")
+	}
+	for _, sec := range obj.Programs {
+		fmt.Printf("  %s\n", sec.Name)
+		for _, fn := range sec.Functions {
+			name := fn.Name
+			if name == "" {
+				name = "<unnamed>"
+			}
+			var relocations int
+			for _, ins := range fn.Program.Instructions {
+				if ins.Relocation != nil {
+					relocations++
+				}
+			}
+			fmt.Printf("    %s offset %d size %d (%d instructions, %d relocations)\n",
+				name, fn.Offset, fn.Size, len(fn.Program.Instructions), relocations)
+		}
+	}
+
+	if len(obj.Maps) > 0 {
+		fmt.Println("maps:")
+	}
+	for _, m := range obj.Maps {
+		if m.Definition == nil {
+			fmt.Printf("  %s (%s)\n", m.Name, m.Section)
+			continue
+		}
+		fmt.Printf("  %s (%s) type %d key %d value %d max_entries %d flags 0x%x\n", m.Name, m.Section,
+			m.Definition.Type, m.Definition.KeySize, m.Definition.ValueSize, m.Definition.MaxEntries, m.Definition.Flags)
+	}
+
+	if len(obj.Globals) > 0 {
+		fmt.Println("globals:")
+	}
+	for _, g := range obj.Globals {
+		fmt.Printf("  %s (%s) offset %d size %d\n", g.Name, g.Section, g.Offset, g.Size)
+	}
+}
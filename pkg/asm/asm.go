@@ -0,0 +1,777 @@
+package asm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
+	"github.com/mtardy/mahebpf/pkg/instruction"
+	"github.com/mtardy/mahebpf/pkg/program"
+)
+
+const (
+	reg = `(r\d+)`
+	// value registers can also be 32-bit subregisters
+	vreg = `([rw]\d+)`
+	num  = `([+-]?(?:0x[0-9a-fA-F]+|\d+))`
+	// jump offsets are printed with their sign, the older listings wrote an
+	// explicit '+' even when negative
+	off  = `(\+?-?\d+)`
+	size = `(u8|u16|u32|u64)`
+	sym  = `([a-zA-Z_.][\w.]*)`
+)
+
+var (
+	// listingPrefix matches the optional line number and instruction bytes
+	// columns of the mahebpf output so that listings can be fed back as is
+	listingPrefix = regexp.MustCompile(`^\d+:\s+(?:[0-9a-f]{16}\s+){0,2}`)
+
+	reFunctionHeader = regexp.MustCompile(`^<[^>]+>:$`)
+	// jumps and local calls can be followed by the label of their target,
+	// like goto +9 <LBB0_20>
+	targetLabel = regexp.MustCompile(` <[^<>\s]+>$`)
+
+	reRaw       = regexp.MustCompile(`^\.8byte (0x[0-9a-fA-F]{1,16})(?:, (0x[0-9a-fA-F]{1,16}))?$`)
+	reExit      = regexp.MustCompile(`^exit$`)
+	reGoto      = regexp.MustCompile(`^goto ` + off + `$`)
+	reGotol     = regexp.MustCompile(`^gotol ` + off + `$`)
+	reCall      = regexp.MustCompile(`^call ` + num + `$`)
+	reCallLocal = regexp.MustCompile(`^call ([+-]\d+)$`)
+	// kernel function calls are written with their BTF ID, and the index of
+	// their module BTF
+	reCallKfunc = regexp.MustCompile(`^call kfunc#` + num + `(?: btf#` + num + `)?$`)
+	// named helper calls can be annotated with their arguments, that are
+	// ignored
+	reCallNamed = regexp.MustCompile(`^call ([a-zA-Z_]\w*?)(?:#(\d+))?(?:\(.*\))?$`)
+	reJump      = regexp.MustCompile(`^if ` + vreg + ` (==|!=|s>=|s<=|s>|s<|>=|<=|>|<|&) (?:` + vreg + `|` + num + `) goto ` + off + `$`)
+	reImm64     = regexp.MustCompile(`^` + reg + ` = ` + num + ` ll$`)
+	// relocated loads are written with their target symbol, the bytes hold
+	// the offset from the symbol
+	reImmSym   = regexp.MustCompile(`^` + reg + ` = (?:map_by_fd\(` + sym + `\)|` + sym + `(?: \+ ` + num + `)?) ll$`)
+	reImmSrc   = regexp.MustCompile(`^` + reg + ` = (map_by_fd|var_addr|code_addr|map_by_idx)\(` + num + `\)$`)
+	reImmVal   = regexp.MustCompile(`^` + reg + ` = map_val\((map_by_fd|map_by_idx)\(` + num + `\)\) \+ ` + num + `$`)
+	reStore    = regexp.MustCompile(`^\*\(` + size + ` \*\)\(` + reg + ` ([+-]) (\d+)\) (\+|\||&|\^)?= (?:` + vreg + `|` + num + `)$`)
+	reFetch    = regexp.MustCompile(`^` + vreg + ` = atomic_fetch_(add|or|and|xor)\(\((u32|u64) \*\)\(` + reg + ` ([+-]) (\d+)\), ` + vreg + `\)$`)
+	reXchg     = regexp.MustCompile(`^` + vreg + ` = xchg(_64|32_32)\(` + reg + ` ([+-]) (\d+), ` + vreg + `\)$`)
+	reCmpXchg  = regexp.MustCompile(`^([rw]0) = cmpxchg(_64|32_32)\(` + reg + ` ([+-]) (\d+), ([rw]0), ` + vreg + `\)$`)
+	rePacket   = regexp.MustCompile(`^r0 = \*\(` + size + ` \*\)skb\[(?:` + reg + `(?: ([+-]) (\d+))?|` + num + `)\]$`)
+	reLoad     = regexp.MustCompile(`^` + reg + ` = \*\((u8|u16|u32|u64|s8|s16|s32) \*\)\(` + reg + ` \+ ` + num + `\)$`)
+	reByteSwap = regexp.MustCompile(`^` + reg + ` = (le|be|bswap)(16|32|64) ` + reg + `$`)
+	reNeg      = regexp.MustCompile(`^` + vreg + ` = -` + vreg + `$`)
+	reShiftReg = regexp.MustCompile(`^` + vreg + ` (<<|>>|s>>)= \(` + vreg + ` & \d+\)$`)
+	reMovSX    = regexp.MustCompile(`^` + vreg + ` = \((s8|s16|s32)\)` + vreg + `$`)
+	reALU      = regexp.MustCompile(`^` + vreg + ` (\+|-|\*|/|\||&|<<|>>|s>>|%|\^|s/|s%)?= (?:` + vreg + `|` + num + `)$`)
+)
+
+var arithmeticOperators = map[string]instruction.OpcodeArithmetic{
+	"+":   instruction.BPF_ADD,
+	"-":   instruction.BPF_SUB,
+	"*":   instruction.BPF_MUL,
+	"/":   instruction.BPF_DIV,
+	"|":   instruction.BPF_OR,
+	"&":   instruction.BPF_AND,
+	"<<":  instruction.BPF_LSH,
+	">>":  instruction.BPF_RSH,
+	"%":   instruction.BPF_MOD,
+	"^":   instruction.BPF_XOR,
+	"":    instruction.BPF_MOV,
+	"s>>": instruction.BPF_ARSH,
+	"s/":  instruction.BPF_DIV,
+	"s%":  instruction.BPF_MOD,
+}
+
+// signedOffsets holds the offset selecting the ISA v4 signed variant of the
+// operators
+var signedOffsets = map[string]instruction.Offset{
+	"s/": 1,
+	"s%": 1,
+}
+
+var signExtensionWidths = map[string]instruction.Offset{
+	"s8":  8,
+	"s16": 16,
+	"s32": 32,
+}
+
+var jumpOperators = map[string]instruction.OpcodeJump{
+	"==":  instruction.BPF_JEQ,
+	">":   instruction.BPF_JGT,
+	">=":  instruction.BPF_JGE,
+	"&":   instruction.BPF_JSET,
+	"!=":  instruction.BPF_JNE,
+	"s>":  instruction.BPF_JSGT,
+	"s>=": instruction.BPF_JSGE,
+	"<":   instruction.BPF_JLT,
+	"<=":  instruction.BPF_JLE,
+	"s<":  instruction.BPF_JSLT,
+	"s<=": instruction.BPF_JSLE,
+}
+
+var arithmeticNames = map[string]instruction.OpcodeArithmetic{
+	"add": instruction.BPF_ADD,
+	"or":  instruction.BPF_OR,
+	"and": instruction.BPF_AND,
+	"xor": instruction.BPF_XOR,
+}
+
+var exchangeSizes = map[string]instruction.OpcodeSize{
+	"32_32": instruction.BPF_W,
+	"_64":   instruction.BPF_DW,
+}
+
+var byteSwaps = map[string]instruction.OpcodeSource{
+	"le": instruction.BPF_TO_LE,
+	"be": instruction.BPF_TO_BE,
+}
+
+var sizes = map[string]instruction.OpcodeSize{
+	"u8":  instruction.BPF_B,
+	"u16": instruction.BPF_H,
+	"u32": instruction.BPF_W,
+	"u64": instruction.BPF_DW,
+	"s8":  instruction.BPF_B,
+	"s16": instruction.BPF_H,
+	"s32": instruction.BPF_W,
+}
+
+var immSources = map[string]instruction.ImmSource{
+	"map_by_fd":  instruction.BPF_IMM1,
+	"var_addr":   instruction.BPF_IMM3,
+	"code_addr":  instruction.BPF_IMM4,
+	"map_by_idx": instruction.BPF_IMM5,
+}
+
+var immValSources = map[string]instruction.ImmSource{
+	"map_by_fd":  instruction.BPF_IMM2,
+	"map_by_idx": instruction.BPF_IMM6,
+}
+
+func encode(opcode instruction.Opcode, dst, src instruction.Register, offset instruction.Offset, imm instruction.Imm) instruction.Instruction {
+	return instruction.Encode(opcode, instruction.NewRegs(dst, src), offset, imm)
+}
+
+func parseRegister(s string) (instruction.Register, error) {
+	if !strings.HasPrefix(s, "r") {
+		return 0, fmt.Errorf("invalid register %q, expected a 64-bit register", s)
+	}
+	n, err := strconv.ParseUint(s[1:], 10, 8)
+	if err != nil || n > uint64(instruction.BPF_R10) {
+		return 0, fmt.Errorf("invalid register %q", s)
+	}
+	return instruction.Register(n), nil
+}
+
+// parseValueRegisters parses registers that must either all be 64-bit
+// registers or all be 32-bit subregisters, empty names are skipped. It
+// returns whether they are subregisters.
+func parseValueRegisters(names ...string) ([]instruction.Register, bool, error) {
+	regs := make([]instruction.Register, 0, len(names))
+	var sub, first = false, true
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		isSub := strings.HasPrefix(name, "w")
+		if !first && isSub != sub {
+			return nil, false, fmt.Errorf("cannot mix registers and subregisters in %s", strings.Join(names, ", "))
+		}
+		sub, first = isSub, false
+		r, err := parseRegister("r" + name[1:])
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid register %q", name)
+		}
+		regs = append(regs, r)
+	}
+	return regs, sub, nil
+}
+
+// aluClass returns the arithmetic class operating on the registers
+func aluClass(sub bool) instruction.OpcodeClass {
+	if sub {
+		return instruction.BPF_ALU
+	}
+	return instruction.BPF_ALU64
+}
+
+// checkAtomicRegisters verifies that the values of a 32-bit atomic operation
+// are held in subregisters
+func checkAtomicRegisters(size instruction.OpcodeSize, sub bool) error {
+	switch {
+	case size == instruction.BPF_W && !sub:
+		return fmt.Errorf("atomic operation on %s must use subregisters", size)
+	case size != instruction.BPF_W && sub:
+		return fmt.Errorf("atomic operation on %s must use 64-bit registers", size)
+	}
+	return nil
+}
+
+func parseNumber(s string, bitSize int) (int64, error) {
+	s = strings.TrimPrefix(s, "+")
+	n, err := strconv.ParseInt(s, 0, bitSize)
+	if err != nil {
+		// accept the unsigned hexadecimal representation of negative values
+		u, uerr := strconv.ParseUint(s, 0, bitSize)
+		if uerr != nil {
+			return 0, fmt.Errorf("invalid number %q", s)
+		}
+		n = int64(u)
+		if bitSize < 64 {
+			n = n << (64 - bitSize) >> (64 - bitSize)
+		}
+	}
+	return n, nil
+}
+
+func parseImm(s string) (instruction.Imm, error) {
+	n, err := parseNumber(s, 32)
+	return instruction.Imm(n), err
+}
+
+func parseOffset(s string) (instruction.Offset, error) {
+	n, err := parseNumber(s, 16)
+	return instruction.Offset(n), err
+}
+
+// parseRegisters parses a list of register names
+func parseRegisters(names ...string) ([]instruction.Register, error) {
+	regs := make([]instruction.Register, 0, len(names))
+	for _, name := range names {
+		r, err := parseRegister(name)
+		if err != nil {
+			return nil, err
+		}
+		regs = append(regs, r)
+	}
+	return regs, nil
+}
+
+// cleanLine removes the columns of the listings around the instruction
+func cleanLine(line string) string {
+	line = program.StripArrows(line)
+	line = strings.Join(strings.Fields(line), " ")
+	line = listingPrefix.ReplaceAllString(line, "")
+	return targetLabel.ReplaceAllString(line, "")
+}
+
+// ParseInstruction assembles a single instruction written in the syntax
+// produced by instruction.Disassemble, for a little-endian program.
+func ParseInstruction(line string) (instruction.Instruction, error) {
+	line = cleanLine(line)
+
+	if m := reRaw.FindStringSubmatch(line); m != nil {
+		return parseRaw(m[1], m[2], binary.LittleEndian)
+	}
+
+	if reExit.MatchString(line) {
+		return encode(instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_EXIT, instruction.BPF_K), 0, 0, 0, 0), nil
+	}
+
+	if m := reGoto.FindStringSubmatch(line); m != nil {
+		offset, err := parseOffset(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encode(instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_JA, instruction.BPF_K), 0, 0, offset, 0), nil
+	}
+
+	if m := reGotol.FindStringSubmatch(line); m != nil {
+		imm, err := parseImm(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encode(instruction.NewJumpOpcode(instruction.BPF_JMP32, instruction.BPF_JA, instruction.BPF_K), 0, 0, 0, imm), nil
+	}
+
+	if m := reCallLocal.FindStringSubmatch(line); m != nil {
+		imm, err := parseImm(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encode(instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_CALL, instruction.BPF_K), 0, 1, 0, imm), nil
+	}
+
+	if m := reCallKfunc.FindStringSubmatch(line); m != nil {
+		imm, err := parseImm(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		var offset instruction.Offset
+		if m[2] != "" {
+			if offset, err = parseOffset(m[2]); err != nil {
+				return instruction.Instruction{}, err
+			}
+		}
+		return encode(instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_CALL, instruction.BPF_K), 0, 2, offset, imm), nil
+	}
+
+	if m := reCall.FindStringSubmatch(line); m != nil {
+		imm, err := parseImm(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encode(instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_CALL, instruction.BPF_K), 0, 0, 0, imm), nil
+	}
+
+	if m := reCallNamed.FindStringSubmatch(line); m != nil {
+		imm, err := parseHelper(m[1], m[2])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encode(instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_CALL, instruction.BPF_K), 0, 0, 0, imm), nil
+	}
+
+	if m := reJump.FindStringSubmatch(line); m != nil {
+		regs, sub, err := parseValueRegisters(m[1], m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		offset, err := parseOffset(m[5])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		class := instruction.BPF_JMP
+		if sub {
+			class = instruction.BPF_JMP32
+		}
+		if len(regs) == 2 {
+			opcode := instruction.NewJumpOpcode(class, jumpOperators[m[2]], instruction.BPF_X)
+			return encode(opcode, regs[0], regs[1], offset, 0), nil
+		}
+		imm, err := parseImm(m[4])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		opcode := instruction.NewJumpOpcode(class, jumpOperators[m[2]], instruction.BPF_K)
+		return encode(opcode, regs[0], 0, offset, imm), nil
+	}
+
+	if m := reImm64.FindStringSubmatch(line); m != nil {
+		dst, err := parseRegister(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		imm64, err := parseNumber(m[2], 64)
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return newImm64(dst, instruction.BPF_IMM0, instruction.Imm64(imm64)), nil
+	}
+
+	if m := reImmSym.FindStringSubmatch(line); m != nil {
+		dst, err := parseRegister(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		var offset int64
+		if m[4] != "" {
+			offset, err = parseNumber(m[4], 64)
+			if err != nil {
+				return instruction.Instruction{}, err
+			}
+		}
+		return newImm64(dst, instruction.BPF_IMM0, instruction.Imm64(offset)), nil
+	}
+
+	if m := reImmSrc.FindStringSubmatch(line); m != nil {
+		dst, err := parseRegister(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		imm, err := parseImm(m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return newImm64(dst, immSources[m[2]], instruction.Imm64(uint32(imm))), nil
+	}
+
+	if m := reImmVal.FindStringSubmatch(line); m != nil {
+		dst, err := parseRegister(m[1])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		imm, err := parseImm(m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		nextImm, err := parseImm(m[4])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return newImm64(dst, immValSources[m[2]], instruction.Imm64(nextImm)<<32|instruction.Imm64(uint32(imm))), nil
+	}
+
+	if m := reStore.FindStringSubmatch(line); m != nil {
+		return parseStore(m)
+	}
+
+	if m := reFetch.FindStringSubmatch(line); m != nil {
+		values, sub, err := parseValueRegisters(m[1], m[7])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		if values[0] != values[1] {
+			return instruction.Instruction{}, fmt.Errorf("atomic fetch must return in its source register, got %s and %s", m[1], m[7])
+		}
+		if err := checkAtomicRegisters(sizes[m[3]], sub); err != nil {
+			return instruction.Instruction{}, err
+		}
+		dst, err := parseRegister(m[4])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		offset, err := parseOffset(m[5] + m[6])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		operation := instruction.AtomicOperation(arithmeticNames[m[2]]) | instruction.AtomicOperation(instruction.BPF_FETCH)
+		return encodeAtomic(sizes[m[3]], dst, values[1], offset, operation), nil
+	}
+
+	if m := reXchg.FindStringSubmatch(line); m != nil {
+		values, sub, err := parseValueRegisters(m[1], m[6])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		if values[0] != values[1] {
+			return instruction.Instruction{}, fmt.Errorf("atomic exchange must return in its source register, got %s and %s", m[1], m[6])
+		}
+		if err := checkAtomicRegisters(exchangeSizes[m[2]], sub); err != nil {
+			return instruction.Instruction{}, err
+		}
+		dst, err := parseRegister(m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		offset, err := parseOffset(m[4] + m[5])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encodeAtomic(exchangeSizes[m[2]], dst, values[1], offset, instruction.BPF_XCHG), nil
+	}
+
+	if m := reCmpXchg.FindStringSubmatch(line); m != nil {
+		values, sub, err := parseValueRegisters(m[1], m[6], m[7])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		if err := checkAtomicRegisters(exchangeSizes[m[2]], sub); err != nil {
+			return instruction.Instruction{}, err
+		}
+		dst, err := parseRegister(m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		offset, err := parseOffset(m[4] + m[5])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encodeAtomic(exchangeSizes[m[2]], dst, values[2], offset, instruction.BPF_CMPXCHG), nil
+	}
+
+	if m := rePacket.FindStringSubmatch(line); m != nil {
+		if m[2] == "" {
+			imm, err := parseImm(m[5])
+			if err != nil {
+				return instruction.Instruction{}, err
+			}
+			opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_LD, sizes[m[1]], instruction.BPF_ABS)
+			return encode(opcode, 0, 0, 0, imm), nil
+		}
+		src, err := parseRegister(m[2])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		var imm instruction.Imm
+		if m[3] != "" {
+			imm, err = parseImm(m[3] + m[4])
+			if err != nil {
+				return instruction.Instruction{}, err
+			}
+		}
+		opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_LD, sizes[m[1]], instruction.BPF_IND)
+		return encode(opcode, 0, src, 0, imm), nil
+	}
+
+	if m := reLoad.FindStringSubmatch(line); m != nil {
+		regs, err := parseRegisters(m[1], m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		offset, err := parseOffset(m[4])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		mode := instruction.BPF_MEM
+		if strings.HasPrefix(m[2], "s") {
+			mode = instruction.BPF_MEMSX
+		}
+		opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_LDX, sizes[m[2]], mode)
+		return encode(opcode, regs[0], regs[1], offset, 0), nil
+	}
+
+	if m := reByteSwap.FindStringSubmatch(line); m != nil {
+		regs, err := parseRegisters(m[1], m[4])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		if regs[0] != regs[1] {
+			return instruction.Instruction{}, fmt.Errorf("byte swap must be done in place, got %s and %s", regs[0], regs[1])
+		}
+		width, err := parseImm(m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		opcode := instruction.NewArithmeticOpcode(instruction.BPF_ALU, instruction.BPF_END, byteSwaps[m[2]])
+		if m[2] == "bswap" {
+			opcode = instruction.NewArithmeticOpcode(instruction.BPF_ALU64, instruction.BPF_END, instruction.BPF_TO_LE)
+		}
+		return encode(opcode, regs[0], 0, 0, width), nil
+	}
+
+	if m := reNeg.FindStringSubmatch(line); m != nil {
+		regs, sub, err := parseValueRegisters(m[1], m[2])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		if regs[0] != regs[1] {
+			return instruction.Instruction{}, fmt.Errorf("neg writes back the register it reads, got %s", line)
+		}
+		opcode := instruction.NewArithmeticOpcode(aluClass(sub), instruction.BPF_NEG, instruction.BPF_K)
+		return encode(opcode, regs[0], 0, 0, 0), nil
+	}
+
+	if m := reShiftReg.FindStringSubmatch(line); m != nil {
+		regs, sub, err := parseValueRegisters(m[1], m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		opcode := instruction.NewArithmeticOpcode(aluClass(sub), arithmeticOperators[m[2]], instruction.BPF_X)
+		return encode(opcode, regs[0], regs[1], 0, 0), nil
+	}
+
+	if m := reMovSX.FindStringSubmatch(line); m != nil {
+		regs, sub, err := parseValueRegisters(m[1], m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		if sub && m[2] == "s32" {
+			return instruction.Instruction{}, fmt.Errorf("sign-extension move from s32 needs 64-bit registers")
+		}
+		opcode := instruction.NewArithmeticOpcode(aluClass(sub), instruction.BPF_MOV, instruction.BPF_X)
+		return encode(opcode, regs[0], regs[1], signExtensionWidths[m[2]], 0), nil
+	}
+
+	if m := reALU.FindStringSubmatch(line); m != nil {
+		regs, sub, err := parseValueRegisters(m[1], m[3])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		offset := signedOffsets[m[2]]
+		if len(regs) == 2 {
+			opcode := instruction.NewArithmeticOpcode(aluClass(sub), arithmeticOperators[m[2]], instruction.BPF_X)
+			return encode(opcode, regs[0], regs[1], offset, 0), nil
+		}
+		imm, err := parseImm(m[4])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		opcode := instruction.NewArithmeticOpcode(aluClass(sub), arithmeticOperators[m[2]], instruction.BPF_K)
+		return encode(opcode, regs[0], 0, offset, imm), nil
+	}
+
+	return instruction.Instruction{}, fmt.Errorf("unknown instruction %q", line)
+}
+
+// parseHelper returns the ID of a helper call written by name, with an
+// optional explicit ID that must agree with the default helper table
+func parseHelper(name, id string) (instruction.Imm, error) {
+	h, known := helper.Default().LookupName(name)
+	if id == "" {
+		if !known {
+			return 0, fmt.Errorf("unknown helper %q", name)
+		}
+		return instruction.Imm(h.ID), nil
+	}
+	imm, err := parseImm(id)
+	if err != nil {
+		return 0, err
+	}
+	if known && instruction.Imm(h.ID) != imm {
+		return 0, fmt.Errorf("helper %s has ID %d, not %d", name, h.ID, imm)
+	}
+	return imm, nil
+}
+
+// ParseInstructionWithByteOrder is ParseInstruction for a program of the
+// given byte order
+func ParseInstructionWithByteOrder(line string, order binary.ByteOrder) (instruction.Instruction, error) {
+	if order != binary.BigEndian {
+		return ParseInstruction(line)
+	}
+	if m := reRaw.FindStringSubmatch(cleanLine(line)); m != nil {
+		return parseRaw(m[1], m[2], order)
+	}
+	ins, err := ParseInstruction(line)
+	if err != nil {
+		return instruction.Instruction{}, err
+	}
+	return bigEndian(ins), nil
+}
+
+// bigEndian encodes the fields of a little-endian instruction for a
+// big-endian program
+func bigEndian(ins instruction.Instruction) instruction.Instruction {
+	be := instruction.Instruction{BigEndian: true}
+	be.SetOpcode(ins.Opcode())
+	be.SetRegs(ins.Regs())
+	be.SetOffset(ins.Offset())
+	be.SetImm(ins.Imm())
+	if ins.Extended64 {
+		be.Pseudo = bigEndian(instruction.NewInstruction(ins.Pseudo)).Basic
+		be.Extended64 = true
+	}
+	return be
+}
+
+// parseRaw reads the data directives written for the instructions that could
+// not be decoded. Like the .8byte directive of the assemblers, the values are
+// words stored in the byte order of the program, see program.DisassembleTolerant.
+func parseRaw(basic, pseudo string, order binary.ByteOrder) (instruction.Instruction, error) {
+	// the instructions hold the bytes in memory order, read as a big-endian
+	// word
+	newInstruction, memoryOrder := instruction.NewInstruction, bits.ReverseBytes64
+	if order == binary.BigEndian {
+		newInstruction, memoryOrder = instruction.NewBigEndianInstruction, func(w uint64) uint64 { return w }
+	}
+	word, err := strconv.ParseUint(basic, 0, 64)
+	if err != nil {
+		return instruction.Instruction{}, err
+	}
+	ins := newInstruction(memoryOrder(word))
+	if pseudo != "" {
+		word, err := strconv.ParseUint(pseudo, 0, 64)
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		ins.AddPseudoInstruction(memoryOrder(word))
+	}
+	return ins, nil
+}
+
+func newImm64(dst instruction.Register, src instruction.ImmSource, imm instruction.Imm64) instruction.Instruction {
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_LD, instruction.BPF_DW, instruction.BPF_IMM)
+	return instruction.EncodeImm64(opcode, instruction.NewRegs(dst, instruction.Register(src)), imm)
+}
+
+func encodeAtomic(size instruction.OpcodeSize, dst, src instruction.Register, offset instruction.Offset, operation instruction.AtomicOperation) instruction.Instruction {
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_STX, size, instruction.BPF_ATOMIC)
+	return encode(opcode, dst, src, offset, instruction.Imm(operation))
+}
+
+// parseStore handles the three instructions that write to memory: stores
+// from register, stores from immediate and atomic operations
+func parseStore(m []string) (instruction.Instruction, error) {
+	dst, err := parseRegister(m[2])
+	if err != nil {
+		return instruction.Instruction{}, err
+	}
+	offset, err := parseOffset(m[3] + m[4])
+	if err != nil {
+		return instruction.Instruction{}, err
+	}
+	size := sizes[m[1]]
+
+	if m[5] != "" {
+		if m[6] == "" {
+			return instruction.Instruction{}, fmt.Errorf("atomic operation needs a source register")
+		}
+		values, sub, err := parseValueRegisters(m[6])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		if err := checkAtomicRegisters(size, sub); err != nil {
+			return instruction.Instruction{}, err
+		}
+		return encodeAtomic(size, dst, values[0], offset, instruction.AtomicOperation(arithmeticOperators[m[5]])), nil
+	}
+
+	if m[6] != "" {
+		src, err := parseRegister(m[6])
+		if err != nil {
+			return instruction.Instruction{}, err
+		}
+		opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_STX, size, instruction.BPF_MEM)
+		return encode(opcode, dst, src, offset, 0), nil
+	}
+
+	imm, err := parseImm(m[7])
+	if err != nil {
+		return instruction.Instruction{}, err
+	}
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_ST, size, instruction.BPF_MEM)
+	return encode(opcode, dst, 0, offset, imm), nil
+}
+
+// stripComment removes the '//' and ';' comments from a line
+func stripComment(line string) string {
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	if i := strings.Index(line, ";"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// Parse assembles a little-endian program written one instruction per line.
+// Empty lines, comments, <function>: headers and label lines are ignored, and
+// so are the jump arrows.
+func Parse(r io.Reader) (*program.Program, error) {
+	return ParseWithByteOrder(r, binary.LittleEndian)
+}
+
+// ParseWithByteOrder is Parse for a program of the given byte order
+func ParseWithByteOrder(r io.Reader, order binary.ByteOrder) (*program.Program, error) {
+	prog := program.NewProgram()
+	scanner := bufio.NewScanner(r)
+	for lineNumber, insNumber := 1, 0; scanner.Scan(); lineNumber++ {
+		line := program.StripArrows(stripComment(scanner.Text()))
+		// function headers and labels of the listings only name the next
+		// instructions
+		if line == "" || reFunctionHeader.MatchString(line) || program.IsLabel(line) {
+			continue
+		}
+		ins, err := ParseInstructionWithByteOrder(line, order)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		prog.Instructions = append(prog.Instructions, program.ProgramInstruction{
+			Instruction: ins,
+			Number:      insNumber,
+		})
+		insNumber++
+		if ins.Extended64 {
+			insNumber++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &prog, nil
+}
+
+func FromFile(path string) (*program.Program, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
@@ -0,0 +1,253 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+	"github.com/mtardy/mahebpf/pkg/program"
+)
+
+func TestParseInstruction(t *testing.T) {
+	tests := []struct {
+		line   string
+		basic  uint64
+		pseudo uint64
+	}{
+		{line: "r1 = 0", basic: 0xb701000000000000},
+		{line: "*(u32 *)(r10 - 4) = r1", basic: 0x631afcff00000000},
+		{line: "*(u64 *)(r1 + 16) = 42", basic: 0x7a0110002a000000},
+		{line: "call 14", basic: 0x850000000e000000},
+		{line: "call +3", basic: 0x8510000003000000},
+		{line: "call kfunc#1", basic: 0x8520000001000000},
+		{line: "call kfunc#1 btf#2", basic: 0x8520020001000000},
+		{line: "call kfunc#-1 btf#-3", basic: 0x8520fdffffffffff},
+		{line: "call bpf_get_current_pid_tgid", basic: 0x850000000e000000},
+		{line: "call bpf_map_lookup_elem#1", basic: 0x8500000001000000},
+		{line: "call bpf_map_update_elem#2(r1=map, r2=fp-4, r3=fp-8, r4=?)", basic: 0x8500000002000000},
+		{line: "call bpf_newer_helper#300", basic: 0x850000002c010000},
+		{line: "r6 = r0", basic: 0xbf06000000000000},
+		{line: "r2 += -4", basic: 0x07020000fcffffff},
+		{line: "r2 += 0xfffffffc", basic: 0x07020000fcffffff},
+		{line: "r1 = 0 ll", basic: 0x1801000000000000, pseudo: 0x0000000000000000},
+		{line: "r1 = -1 ll", basic: 0x18010000ffffffff, pseudo: 0x00000000ffffffff},
+		{line: "r2 = map_by_fd(3)", basic: 0x1812000003000000},
+		{line: "r1 = map_by_fd(events) ll", basic: 0x1801000000000000},
+		{line: "r2 = .rodata + 16 ll", basic: 0x1802000010000000},
+		{line: "r3 = counter ll", basic: 0x1803000000000000},
+		{line: "r2 = map_val(map_by_idx(1)) + 8", basic: 0x1862000001000000, pseudo: 0x0000000008000000},
+		{line: "if r0 != 0 goto +9", basic: 0x5500090000000000},
+		{line: "if r1 s> r2 goto +-3", basic: 0x6d21fdff00000000},
+		{line: "goto +1", basic: 0x0500010000000000},
+		{line: "goto -3", basic: 0x0500fdff00000000},
+		{line: "if r0 != 0 goto +9 <LBB0_20>", basic: 0x5500090000000000},
+		{line: "if r0 < 5 goto -2 <LBB0_1>", basic: 0xa500feff05000000},
+		{line: "|`- 2: a500feff05000000 if r0 < 5 goto -2 <LBB0_1>", basic: 0xa500feff05000000},
+		{line: "``> 5: 8510000001000000 call +1", basic: 0x8510000001000000},
+		{line: "call +3 <handle_event>", basic: 0x8510000003000000},
+		{line: "call -3 <LBB0_0>", basic: 0x85100000fdffffff},
+		{line: "gotol +65536", basic: 0x0600000000000100},
+		{line: "gotol +-2", basic: 0x06000000feffffff},
+		{line: "r1 = *(u64 *)(r7 + 4160)", basic: 0x7971401000000000},
+		{line: "r1 = *(s16 *)(r2 + -2)", basic: 0x8921feff00000000},
+		{line: "*(u64 *)(r10 - 8) += r1", basic: 0xdb1af8ff00000000},
+		{line: "r1 <<= 3", basic: 0x6701000003000000},
+		{line: "r1 s>>= (r2 & 63)", basic: 0xcf21000000000000},
+		{line: "r1 = -r1", basic: 0x8701000000000000},
+		{line: "r1 s/= r2", basic: 0x3f21010000000000},
+		{line: "w1 s%= 3", basic: 0x9401010003000000},
+		{line: "r1 = (s8)r2", basic: 0xbf21080000000000},
+		{line: "w1 = (s16)w2", basic: 0xbc21100000000000},
+		{line: "r1 = atomic_fetch_add((u64 *)(r10 - 8), r1)", basic: 0xdb1af8ff01000000},
+		{line: "w2 = atomic_fetch_xor((u32 *)(r1 + 4), w2)", basic: 0xc3210400a1000000},
+		{line: "r1 = xchg_64(r10 - 8, r1)", basic: 0xdb1af8ffe1000000},
+		{line: "w0 = cmpxchg32_32(r10 - 8, w0, w1)", basic: 0xc31af8fff1000000},
+		{line: "*(u32 *)(r10 - 8) += w1", basic: 0xc31af8ff00000000},
+		{line: "w1 += 3", basic: 0x0401000003000000},
+		{line: "w1 = w2", basic: 0xbc21000000000000},
+		{line: "w1 <<= (w2 & 31)", basic: 0x6c21000000000000},
+		{line: "w1 = -w1", basic: 0x8401000000000000},
+		{line: "if w1 > w2 goto +3", basic: 0x2e21030000000000},
+		{line: "if w0 s< -1 goto +2", basic: 0xc6000200ffffffff},
+		{line: "r0 = *(u16 *)skb[12]", basic: 0x280000000c000000},
+		{line: "r0 = *(u8 *)skb[r1 + 14]", basic: 0x501000000e000000},
+		{line: "r0 = *(u32 *)skb[r2 - 2]", basic: 0x40200000feffffff},
+		{line: "r1 = be16 r1", basic: 0xdc01000010000000},
+		{line: "r1 = le64 r1", basic: 0xd401000040000000},
+		{line: "r1 = bswap32 r1", basic: 0xd701000020000000},
+		{line: "exit", basic: 0x9500000000000000},
+		{line: ".8byte 0x00000000000001e7", basic: 0xe701000000000000},
+		{line: ".8byte 0x0000000000000718, 0x0000000100000000", basic: 0x1807000000000000, pseudo: 0x0000000001000000},
+		{line: "  7: 1801000000000000 0000000000000000 r1 = 0 ll", basic: 0x1801000000000000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			ins, err := ParseInstruction(tt.line)
+			if err != nil {
+				t.Fatalf("ParseInstruction() error = %v", err)
+			}
+			if ins.Basic != tt.basic {
+				t.Errorf("ParseInstruction() basic = %016x, want %016x", ins.Basic, tt.basic)
+			}
+			if ins.Pseudo != tt.pseudo {
+				t.Errorf("ParseInstruction() pseudo = %016x, want %016x", ins.Pseudo, tt.pseudo)
+			}
+		})
+	}
+}
+
+func TestParseInstructionErrors(t *testing.T) {
+	for _, line := range []string{
+		"r11 = 0",
+		"r1 = 0x100000000",
+		"goto +70000",
+		"mov r1, 0",
+		"r1 = be16 r2",
+		"r1 = xchg_64(r10 - 8, r2)",
+		"call bpf_unknown",
+		"call bpf_map_lookup_elem#2",
+		"w1 += r2",
+		"w1 = (s32)w2",
+		"r1 = (s8)w2",
+		"if w1 > r2 goto +3",
+		"w1 = *(u32 *)(r10 - 4)",
+		"*(u64 *)(r10 - 8) += w1",
+		"r1 = xchg32_32(r10 - 8, r1)",
+		"call +-3",
+		"r1 = -r2",
+	} {
+		if _, err := ParseInstruction(line); err == nil {
+			t.Errorf("ParseInstruction(%q) expected an error", line)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rawInstructions := []uint64{
+		0xbf16000000000000,
+		0x631afcff00000000,
+		0x07020000fcffffff,
+		0x8500000001000000,
+		0x1507790000000000,
+		0x0f13000000000000,
+		0x79a9f0ff00000000,
+		0x5701000004000000,
+		0x5d125c0000000000,
+		0x6b17000000000000,
+		0x61a9f0ff00000000,
+		0x0500010000000000,
+		0xdc01000010000000,
+		0xd701000040000000,
+		0xdb1af8ff01000000,
+		0xdb1af8ffe1000000,
+		0xc31af8fff1000000,
+		0xc31af8ff00000000,
+		0x0401000003000000,
+		0xbc21000000000000,
+		0x6c21000000000000,
+		0x2e21030000000000,
+		0x0600000000000100,
+		0x3701000003000000,
+		0x3f21010000000000,
+		0x9401010003000000,
+		0xbf21200000000000,
+		0xc6000200ffffffff,
+		0x280000000c000000,
+		0x5010000000000000,
+		0x8520000001000000,
+		0x8520020001000000,
+		0x9500000000000000,
+	}
+	for _, raw := range rawInstructions {
+		ins := instruction.NewInstruction(raw)
+		text, err := ins.Disassemble()
+		if err != nil {
+			t.Fatalf("Disassemble() error = %v", err)
+		}
+		got, err := ParseInstruction(text)
+		if err != nil {
+			t.Errorf("ParseInstruction(%q) error = %v", text, err)
+			continue
+		}
+		if got != ins {
+			t.Errorf("ParseInstruction(%q) = %016x, want %016x", text, got.Basic, raw)
+		}
+	}
+}
+
+func TestRoundTripBigEndian(t *testing.T) {
+	// r1 = 0, an undecodable slot, r2 = 0x10000002a ll, if r1 != 0 goto +1,
+	// call 14 and exit, of a big-endian program
+	data := []byte{
+		0xb7, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xe7, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x18, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2a,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x55, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x85, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0e,
+		0x95, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	prog, err := program.ReadRaw(bytes.NewReader(data), binary.BigEndian)
+	if err != nil {
+		t.Fatalf("ReadRaw() error = %v", err)
+	}
+	disassembled, err := prog.DisassembleTolerant()
+	if err == nil {
+		t.Fatal("DisassembleTolerant() expected an error for the undecodable slot")
+	}
+	var source strings.Builder
+	for _, d := range disassembled {
+		source.WriteString(d.Disassembled + "\n")
+	}
+
+	got, err := ParseWithByteOrder(strings.NewReader(source.String()), binary.BigEndian)
+	if err != nil {
+		t.Fatalf("ParseWithByteOrder() error = %v", err)
+	}
+	var out bytes.Buffer
+	for _, ins := range got.Instructions {
+		b, err := ins.Instruction.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary() error = %v", err)
+		}
+		out.Write(b)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("ParseWithByteOrder(%q) = % x, want % x", source.String(), out.Bytes(), data)
+	}
+}
+
+func TestParse(t *testing.T) {
+	source := `
+	// a small program
+	<prog>:
+	r1 = 0
+	r2 = 0 ll ; two slots
+|  LBB0_4:
+	call 1
+
+	exit
+	`
+	prog, err := Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	wantNumbers := []int{0, 1, 3, 4}
+	if len(prog.Instructions) != len(wantNumbers) {
+		t.Fatalf("Parse() got %d instructions, want %d", len(prog.Instructions), len(wantNumbers))
+	}
+	for i, ins := range prog.Instructions {
+		if ins.Number != wantNumbers[i] {
+			t.Errorf("instruction %d has number %d, want %d", i, ins.Number, wantNumbers[i])
+		}
+	}
+
+	_, err = Parse(strings.NewReader("r1 = 0\nr1 = what\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Parse() error = %v, want an error on line 2", err)
+	}
+}
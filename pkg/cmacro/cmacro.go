@@ -0,0 +1,521 @@
+// Package cmacro reads and writes programs as C arrays of struct bpf_insn, in
+// the BPF_MOV64_IMM(BPF_REG_0, 0), BPF_EXIT_INSN() form of the kernel
+// include/linux/filter.h macros used by the selftests and many loaders.
+package cmacro
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
+	"github.com/mtardy/mahebpf/pkg/instruction"
+	"github.com/mtardy/mahebpf/pkg/program"
+)
+
+// constants are the values of the UAPI constants, as used in the macros and
+// in the code of the raw instructions
+var constants = map[string]int64{
+	// classes
+	"BPF_LD": 0x00, "BPF_LDX": 0x01, "BPF_ST": 0x02, "BPF_STX": 0x03,
+	"BPF_ALU": 0x04, "BPF_JMP": 0x05, "BPF_JMP32": 0x06, "BPF_ALU64": 0x07,
+	// sizes
+	"BPF_W": 0x00, "BPF_H": 0x08, "BPF_B": 0x10, "BPF_DW": 0x18,
+	// modes
+	"BPF_IMM": 0x00, "BPF_ABS": 0x20, "BPF_IND": 0x40, "BPF_MEM": 0x60,
+	"BPF_MEMSX": 0x80, "BPF_ATOMIC": 0xc0, "BPF_XADD": 0xc0,
+	// sources
+	"BPF_K": 0x00, "BPF_X": 0x08,
+	"BPF_TO_LE": 0x00, "BPF_TO_BE": 0x08, "BPF_FROM_LE": 0x00, "BPF_FROM_BE": 0x08,
+	// arithmetic operations
+	"BPF_ADD": 0x00, "BPF_SUB": 0x10, "BPF_MUL": 0x20, "BPF_DIV": 0x30,
+	"BPF_OR": 0x40, "BPF_AND": 0x50, "BPF_LSH": 0x60, "BPF_RSH": 0x70,
+	"BPF_NEG": 0x80, "BPF_MOD": 0x90, "BPF_XOR": 0xa0, "BPF_MOV": 0xb0,
+	"BPF_ARSH": 0xc0, "BPF_END": 0xd0,
+	// jump operations
+	"BPF_JA": 0x00, "BPF_JEQ": 0x10, "BPF_JGT": 0x20, "BPF_JGE": 0x30,
+	"BPF_JSET": 0x40, "BPF_JNE": 0x50, "BPF_JSGT": 0x60, "BPF_JSGE": 0x70,
+	"BPF_CALL": 0x80, "BPF_EXIT": 0x90, "BPF_JLT": 0xa0, "BPF_JLE": 0xb0,
+	"BPF_JSLT": 0xc0, "BPF_JSLE": 0xd0,
+	// atomic operations
+	"BPF_FETCH": 0x01, "BPF_XCHG": 0xe1, "BPF_CMPXCHG": 0xf1,
+	// src_reg of the 64-bit immediate loads and of the calls
+	"BPF_PSEUDO_MAP_FD": 1, "BPF_PSEUDO_MAP_VALUE": 2, "BPF_PSEUDO_BTF_ID": 3,
+	"BPF_PSEUDO_FUNC": 4, "BPF_PSEUDO_MAP_IDX": 5, "BPF_PSEUDO_MAP_IDX_VALUE": 6,
+	"BPF_PSEUDO_CALL": 1, "BPF_PSEUDO_KFUNC_CALL": 2,
+	// registers
+	"BPF_REG_0": 0, "BPF_REG_1": 1, "BPF_REG_2": 2, "BPF_REG_3": 3,
+	"BPF_REG_4": 4, "BPF_REG_5": 5, "BPF_REG_6": 6, "BPF_REG_7": 7,
+	"BPF_REG_8": 8, "BPF_REG_9": 9, "BPF_REG_10": 10, "BPF_REG_FP": 10,
+}
+
+// rawInsn is a struct bpf_insn, a single instruction slot
+type rawInsn struct {
+	code     uint8
+	dst, src uint8
+	off      int16
+	imm      int32
+}
+
+func ld(dst, src, off1, off2, imm1, imm2 int64) []rawInsn {
+	return []rawInsn{
+		{code: codeLDDW, dst: uint8(dst), src: uint8(src), off: int16(off1), imm: int32(imm1)},
+		{off: int16(off2), imm: int32(imm2)},
+	}
+}
+
+func ld64(dst, src, imm int64) []rawInsn {
+	return ld(dst, src, 0, 0, int64(uint32(imm)), int64(uint64(imm)>>32))
+}
+
+func one(code, dst, src, off, imm int64) []rawInsn {
+	return []rawInsn{{code: uint8(code), dst: uint8(dst), src: uint8(src), off: int16(off), imm: int32(imm)}}
+}
+
+const (
+	classLD    = 0x00
+	classLDX   = 0x01
+	classST    = 0x02
+	classSTX   = 0x03
+	classALU   = 0x04
+	classJMP   = 0x05
+	classJMP32 = 0x06
+	classALU64 = 0x07
+	sourceX    = 0x08
+	modeABS    = 0x20
+	modeIND    = 0x40
+	modeMEM    = 0x60
+	modeMEMSX  = 0x80
+	modeATOMIC = 0xc0
+	opMOV      = 0xb0
+	opEND      = 0xd0
+	opCALL     = 0x80
+	opEXIT     = 0x90
+	codeLDDW   = classLD | 0x18
+)
+
+// macro expands the arguments of a macro to instruction slots
+type macro struct {
+	args   int
+	expand func(a []int64) []rawInsn
+}
+
+var macros = map[string]macro{
+	"BPF_ALU64_REG": {3, func(a []int64) []rawInsn { return one(classALU64|a[0]|sourceX, a[1], a[2], 0, 0) }},
+	"BPF_ALU32_REG": {3, func(a []int64) []rawInsn { return one(classALU|a[0]|sourceX, a[1], a[2], 0, 0) }},
+	"BPF_ALU64_IMM": {3, func(a []int64) []rawInsn { return one(classALU64|a[0], a[1], 0, 0, a[2]) }},
+	"BPF_ALU32_IMM": {3, func(a []int64) []rawInsn { return one(classALU|a[0], a[1], 0, 0, a[2]) }},
+	"BPF_ENDIAN":    {3, func(a []int64) []rawInsn { return one(classALU|opEND|a[0], a[1], 0, 0, a[2]) }},
+	"BPF_BSWAP":     {2, func(a []int64) []rawInsn { return one(classALU64|opEND, a[0], 0, 0, a[1]) }},
+	"BPF_MOV64_REG": {2, func(a []int64) []rawInsn { return one(classALU64|opMOV|sourceX, a[0], a[1], 0, 0) }},
+	"BPF_MOV32_REG": {2, func(a []int64) []rawInsn { return one(classALU|opMOV|sourceX, a[0], a[1], 0, 0) }},
+	"BPF_MOV64_IMM": {2, func(a []int64) []rawInsn { return one(classALU64|opMOV, a[0], 0, 0, a[1]) }},
+	"BPF_MOV32_IMM": {2, func(a []int64) []rawInsn { return one(classALU|opMOV, a[0], 0, 0, a[1]) }},
+	"BPF_MOVSX64_REG": {3, func(a []int64) []rawInsn {
+		return one(classALU64|opMOV|sourceX, a[0], a[1], a[2], 0)
+	}},
+	"BPF_MOVSX32_REG": {3, func(a []int64) []rawInsn {
+		return one(classALU|opMOV|sourceX, a[0], a[1], a[2], 0)
+	}},
+	"BPF_ZEXT_REG":  {1, func(a []int64) []rawInsn { return one(classALU|opMOV|sourceX, a[0], a[0], 0, 1) }},
+	"BPF_MOV64_RAW": {4, func(a []int64) []rawInsn { return one(classALU64|opMOV|a[0], a[1], a[2], 0, a[3]) }},
+	"BPF_MOV32_RAW": {4, func(a []int64) []rawInsn { return one(classALU|opMOV|a[0], a[1], a[2], 0, a[3]) }},
+	"BPF_LD_IMM64":  {2, func(a []int64) []rawInsn { return ld64(a[0], 0, a[1]) }},
+	"BPF_LD_IMM64_RAW": {3, func(a []int64) []rawInsn {
+		return ld64(a[0], a[1], a[2])
+	}},
+	"BPF_LD_IMM64_RAW_FULL": {6, func(a []int64) []rawInsn { return ld(a[0], a[1], a[2], a[3], a[4], a[5]) }},
+	"BPF_LD_MAP_FD":         {2, func(a []int64) []rawInsn { return ld64(a[0], 1, a[1]) }},
+	"BPF_LD_MAP_VALUE":      {3, func(a []int64) []rawInsn { return ld(a[0], 2, 0, 0, a[1], a[2]) }},
+	"BPF_LD_ABS":            {2, func(a []int64) []rawInsn { return one(classLD|a[0]|modeABS, 0, 0, 0, a[1]) }},
+	"BPF_LD_IND":            {3, func(a []int64) []rawInsn { return one(classLD|a[0]|modeIND, 0, a[1], 0, a[2]) }},
+	"BPF_LDX_MEM":           {4, func(a []int64) []rawInsn { return one(classLDX|a[0]|modeMEM, a[1], a[2], a[3], 0) }},
+	"BPF_LDX_MEMSX":         {4, func(a []int64) []rawInsn { return one(classLDX|a[0]|modeMEMSX, a[1], a[2], a[3], 0) }},
+	"BPF_STX_MEM":           {4, func(a []int64) []rawInsn { return one(classSTX|a[0]|modeMEM, a[1], a[2], a[3], 0) }},
+	"BPF_ST_MEM":            {4, func(a []int64) []rawInsn { return one(classST|a[0]|modeMEM, a[1], 0, a[2], a[3]) }},
+	"BPF_ATOMIC_OP": {5, func(a []int64) []rawInsn {
+		return one(classSTX|a[0]|modeATOMIC, a[2], a[3], a[4], a[1])
+	}},
+	"BPF_STX_XADD":  {4, func(a []int64) []rawInsn { return one(classSTX|a[0]|modeATOMIC, a[1], a[2], a[3], 0) }},
+	"BPF_JMP_REG":   {4, func(a []int64) []rawInsn { return one(classJMP|a[0]|sourceX, a[1], a[2], a[3], 0) }},
+	"BPF_JMP_IMM":   {4, func(a []int64) []rawInsn { return one(classJMP|a[0], a[1], 0, a[3], a[2]) }},
+	"BPF_JMP32_REG": {4, func(a []int64) []rawInsn { return one(classJMP32|a[0]|sourceX, a[1], a[2], a[3], 0) }},
+	"BPF_JMP32_IMM": {4, func(a []int64) []rawInsn { return one(classJMP32|a[0], a[1], 0, a[3], a[2]) }},
+	"BPF_JMP_A":     {1, func(a []int64) []rawInsn { return one(classJMP, 0, 0, a[0], 0) }},
+	"BPF_JMP32_A":   {1, func(a []int64) []rawInsn { return one(classJMP32, 0, 0, 0, a[0]) }},
+	"BPF_CALL_REL":  {1, func(a []int64) []rawInsn { return one(classJMP|opCALL, 0, 1, 0, a[0]) }},
+	"BPF_EMIT_CALL": {1, func(a []int64) []rawInsn { return one(classJMP|opCALL, 0, 0, 0, a[0]) }},
+	"BPF_RAW_INSN":  {5, func(a []int64) []rawInsn { return one(a[0], a[1], a[2], a[3], a[4]) }},
+	"BPF_EXIT_INSN": {0, func(a []int64) []rawInsn { return one(classJMP|opEXIT, 0, 0, 0, 0) }},
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// lex splits C source in tokens, comments and string literals are skipped
+func lex(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != c {
+				return nil, fmt.Errorf("line %d: unterminated literal", line)
+			}
+			i = j + 1
+		case isIdentChar(c):
+			j := i
+			for j < len(src) && isIdentChar(src[j]) {
+				j++
+			}
+			kind := tokenIdent
+			if c >= '0' && c <= '9' {
+				kind = tokenNumber
+			}
+			tokens = append(tokens, token{kind: kind, text: src[i:j], line: line})
+			i = j
+		case strings.HasPrefix(src[i:], "<<") || strings.HasPrefix(src[i:], ">>"):
+			tokens = append(tokens, token{kind: tokenPunct, text: src[i : i+2], line: line})
+			i += 2
+		default:
+			tokens = append(tokens, token{kind: tokenPunct, text: src[i : i+1], line: line})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func parseNumber(text string) (int64, error) {
+	digits := strings.TrimRight(text, "uUlL")
+	if v, err := strconv.ParseInt(digits, 0, 64); err == nil {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(digits, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", text)
+	}
+	return int64(v), nil
+}
+
+// evaluator computes the constant integer expressions of the macro arguments
+type evaluator struct {
+	tokens  []token
+	pos     int
+	helpers *helper.Table
+}
+
+func (e *evaluator) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos].text
+	}
+	return ""
+}
+
+func (e *evaluator) binary(operators []string, operand func() (int64, error), apply func(op string, a, b int64) int64) (int64, error) {
+	v, err := operand()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := e.peek()
+		found := false
+		for _, o := range operators {
+			found = found || o == op
+		}
+		if !found {
+			return v, nil
+		}
+		e.pos++
+		w, err := operand()
+		if err != nil {
+			return 0, err
+		}
+		v = apply(op, v, w)
+	}
+}
+
+func (e *evaluator) or() (int64, error) {
+	return e.binary([]string{"|"}, e.and, func(_ string, a, b int64) int64 { return a | b })
+}
+
+func (e *evaluator) and() (int64, error) {
+	return e.binary([]string{"&"}, e.shift, func(_ string, a, b int64) int64 { return a & b })
+}
+
+func (e *evaluator) shift() (int64, error) {
+	return e.binary([]string{"<<", ">>"}, e.sum, func(op string, a, b int64) int64 {
+		if op == "<<" {
+			return a << b
+		}
+		return a >> b
+	})
+}
+
+func (e *evaluator) sum() (int64, error) {
+	return e.binary([]string{"+", "-"}, e.unary, func(op string, a, b int64) int64 {
+		if op == "+" {
+			return a + b
+		}
+		return a - b
+	})
+}
+
+func (e *evaluator) unary() (int64, error) {
+	switch e.peek() {
+	case "-":
+		e.pos++
+		v, err := e.unary()
+		return -v, err
+	case "~":
+		e.pos++
+		v, err := e.unary()
+		return ^v, err
+	case "+":
+		e.pos++
+		return e.unary()
+	}
+	return e.primary()
+}
+
+func (e *evaluator) primary() (int64, error) {
+	if e.pos >= len(e.tokens) {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	t := e.tokens[e.pos]
+	e.pos++
+	switch {
+	case t.text == "(":
+		v, err := e.or()
+		if err != nil {
+			return 0, err
+		}
+		if e.peek() != ")" {
+			return 0, fmt.Errorf("missing ) in expression")
+		}
+		e.pos++
+		return v, nil
+	case t.kind == tokenNumber:
+		return parseNumber(t.text)
+	case t.kind == tokenIdent:
+		if v, ok := constants[t.text]; ok {
+			return v, nil
+		}
+		if name, ok := strings.CutPrefix(t.text, "BPF_FUNC_"); ok && e.helpers != nil {
+			if h, ok := e.helpers.LookupName("bpf_" + name); ok {
+				return int64(h.ID), nil
+			}
+		}
+		return 0, fmt.Errorf("unknown identifier %s", t.text)
+	default:
+		return 0, fmt.Errorf("unexpected %q in expression", t.text)
+	}
+}
+
+func evaluate(tokens []token, helpers *helper.Table) (int64, error) {
+	if len(tokens) == 0 {
+		return 0, fmt.Errorf("empty expression")
+	}
+	e := evaluator{tokens: tokens, helpers: helpers}
+	v, err := e.or()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos != len(tokens) {
+		return 0, fmt.Errorf("unexpected %q in expression", e.peek())
+	}
+	return v, nil
+}
+
+// splitArgs returns the comma separated arguments of the parenthesized list
+// starting after tokens[start-1], and the index following its closing
+// parenthesis
+func splitArgs(tokens []token, start int, closing string) ([][]token, int, error) {
+	var args [][]token
+	var current []token
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.text == "(" || t.text == "{":
+			depth++
+		case (t.text == ")" || t.text == "}") && depth > 0:
+			depth--
+		case t.text == closing && depth == 0:
+			if len(current) > 0 || len(args) > 0 {
+				args = append(args, current)
+			}
+			return args, i + 1, nil
+		case t.text == "," && depth == 0:
+			args = append(args, current)
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	return nil, 0, fmt.Errorf("missing %s", closing)
+}
+
+// initializerFields are the indexes of the struct bpf_insn fields in the
+// arguments of one
+var initializerFields = map[string]int{"code": 0, "dst_reg": 1, "src_reg": 2, "off": 3, "imm": 4}
+
+// initializer expands a { .code = ..., .dst_reg = ..., ... } struct
+// initializer, the fields that are not set are zero
+func initializer(fields [][]token, helpers *helper.Table) ([]rawInsn, error) {
+	var values [5]int64
+	for _, field := range fields {
+		if len(field) < 3 || field[0].text != "." || field[2].text != "=" {
+			return nil, fmt.Errorf("invalid struct bpf_insn field")
+		}
+		i, ok := initializerFields[field[1].text]
+		if !ok {
+			return nil, fmt.Errorf("unknown struct bpf_insn field %s", field[1].text)
+		}
+		v, err := evaluate(field[3:], helpers)
+		if err != nil {
+			return nil, fmt.Errorf(".%s: %w", field[1].text, err)
+		}
+		values[i] = v
+	}
+	return one(values[0], values[1], values[2], values[3], values[4]), nil
+}
+
+// isInitializer reports whether the tokens start a designated initializer of
+// struct bpf_insn
+func isInitializer(tokens []token, i int) bool {
+	if tokens[i].text != "{" || i+2 >= len(tokens) || tokens[i+1].text != "." {
+		return false
+	}
+	_, ok := initializerFields[tokens[i+2].text]
+	return ok
+}
+
+// Parse reads the instructions written with the struct bpf_insn macros or
+// initializers, the surrounding C code like the array declaration is ignored.
+// Helpers are named with their BPF_FUNC_ constants.
+func Parse(r io.Reader) (*program.Program, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := lex(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	helpers := helper.Default()
+	var slots []rawInsn
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case t.kind == tokenIdent && i+1 < len(tokens) && tokens[i+1].text == "(" && strings.HasPrefix(t.text, "BPF_"):
+			m, ok := macros[t.text]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown macro %s", t.line, t.text)
+			}
+			args, next, err := splitArgs(tokens, i+2, ")")
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", t.line, t.text, err)
+			}
+			if len(args) != m.args {
+				return nil, fmt.Errorf("line %d: %s takes %d arguments, got %d", t.line, t.text, m.args, len(args))
+			}
+			values := make([]int64, len(args))
+			for j, arg := range args {
+				if values[j], err = evaluate(arg, helpers); err != nil {
+					return nil, fmt.Errorf("line %d: %s: %w", t.line, t.text, err)
+				}
+			}
+			slots = append(slots, m.expand(values)...)
+			i = next
+		case isInitializer(tokens, i):
+			fields, next, err := splitArgs(tokens, i+1, "}")
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", t.line, err)
+			}
+			insns, err := initializer(fields, helpers)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", t.line, err)
+			}
+			slots = append(slots, insns...)
+			i = next
+		default:
+			i++
+		}
+	}
+	return newProgram(slots)
+}
+
+// newProgram pairs the 64-bit immediate loads with their second slot
+func newProgram(slots []rawInsn) (*program.Program, error) {
+	prog := program.NewProgram()
+	for i := 0; i < len(slots); i++ {
+		s := slots[i]
+		ins := instruction.Encode(instruction.Opcode(s.code), instruction.NewRegs(instruction.Register(s.dst), instruction.Register(s.src)), instruction.Offset(s.off), instruction.Imm(s.imm))
+		number := i
+		if ins.NeedPseudoInstruction() {
+			if i+1 >= len(slots) {
+				return nil, fmt.Errorf("ins %d needs a pseudo instruction and it's not available", i)
+			}
+			i++
+			next := slots[i]
+			pseudo := instruction.Encode(instruction.Opcode(next.code), instruction.NewRegs(instruction.Register(next.dst), instruction.Register(next.src)), instruction.Offset(next.off), instruction.Imm(next.imm))
+			ins.AddPseudoInstruction(pseudo.Basic)
+		}
+		prog.Instructions = append(prog.Instructions, program.ProgramInstruction{
+			Instruction: ins,
+			Number:      number,
+		})
+	}
+	return &prog, nil
+}
+
+func FromFile(path string) (*program.Program, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
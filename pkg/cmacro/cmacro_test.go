@@ -0,0 +1,87 @@
+package cmacro
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	src := `
+/* a verifier test */
+static struct bpf_insn insns[] = {
+	BPF_MOV64_IMM(BPF_REG_0, 0),
+	BPF_ST_MEM(BPF_W, BPF_REG_10, -4, 0),
+	BPF_MOV64_REG(BPF_REG_2, BPF_REG_FP),
+	BPF_ALU64_IMM(BPF_ADD, BPF_REG_2, -4), // pointer to the key
+	BPF_LD_MAP_FD(BPF_REG_1, 0),
+	BPF_EMIT_CALL(BPF_FUNC_map_lookup_elem),
+	BPF_JMP_IMM(BPF_JEQ, BPF_REG_0, 0, 1),
+	BPF_ATOMIC_OP(BPF_DW, BPF_ADD | BPF_FETCH, BPF_REG_0, BPF_REG_1, 8),
+	BPF_LD_IMM64(BPF_REG_3, 0x1122334455667788ULL),
+	BPF_RAW_INSN(BPF_JMP32 | BPF_JSLT | BPF_K, BPF_REG_0, 0, 2, -1),
+	{ .code = BPF_ALU64 | BPF_MOV | BPF_K, .dst_reg = BPF_REG_0, .imm = 1 << 4 },
+	((struct bpf_insn) { .code = BPF_JMP | BPF_EXIT }),
+	BPF_EXIT_INSN(),
+};
+`
+	prog, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []struct {
+		number        int
+		basic, pseudo uint64
+	}{
+		{0, 0xb700000000000000, 0},
+		{1, 0x620afcff00000000, 0},
+		{2, 0xbfa2000000000000, 0},
+		{3, 0x07020000fcffffff, 0},
+		{4, 0x1811000000000000, 0},
+		{6, 0x8500000001000000, 0},
+		{7, 0x1500010000000000, 0},
+		{8, 0xdb10080001000000, 0},
+		{9, 0x1803000088776655, 0x0000000044332211},
+		{11, 0xc6000200ffffffff, 0},
+		{12, 0xb700000010000000, 0},
+		{13, 0x9500000000000000, 0},
+		{14, 0x9500000000000000, 0},
+	}
+	if len(prog.Instructions) != len(want) {
+		t.Fatalf("Parse() got %d instructions, want %d", len(prog.Instructions), len(want))
+	}
+	for i, w := range want {
+		got := prog.Instructions[i]
+		if got.Number != w.number || got.Instruction.Basic != w.basic || got.Instruction.Pseudo != w.pseudo {
+			t.Errorf("instruction %d = %d: %v, want %d: %016x %016x", i, got.Number, got.Instruction, w.number, w.basic, w.pseudo)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{src: "BPF_MOV64_IMM(BPF_REG_0)", want: "line 1: BPF_MOV64_IMM takes 2 arguments, got 1"},
+		{src: "\nBPF_FANCY_INSN(1)", want: "line 2: unknown macro BPF_FANCY_INSN"},
+		{src: "BPF_MOV64_IMM(BPF_REG_0, FOO)", want: "unknown identifier FOO"},
+		{src: "BPF_EMIT_CALL(BPF_FUNC_pizza)", want: "unknown identifier BPF_FUNC_pizza"},
+		{src: "BPF_MOV64_IMM(BPF_REG_0, (1 + 2)", want: "missing )"},
+		{src: "{ .code = 0x95, .pizza = 1 }", want: "unknown struct bpf_insn field pizza"},
+		{src: "BPF_LD_IMM64_RAW_FULL(1, 0, 0, 0, 0, 0),", want: ""},
+		{src: "BPF_RAW_INSN(0x18, 1, 0, 0, 0)", want: "needs a pseudo instruction"},
+		{src: "/* never closed", want: "unterminated comment"},
+	} {
+		_, err := Parse(strings.NewReader(tt.src))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("Parse(%q) error = %v", tt.src, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
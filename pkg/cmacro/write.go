@@ -0,0 +1,257 @@
+package cmacro
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
+	"github.com/mtardy/mahebpf/pkg/instruction"
+	"github.com/mtardy/mahebpf/pkg/program"
+)
+
+var (
+	classNames = []string{"BPF_LD", "BPF_LDX", "BPF_ST", "BPF_STX", "BPF_ALU", "BPF_JMP", "BPF_JMP32", "BPF_ALU64"}
+	sizeNames  = map[uint8]string{0x00: "BPF_W", 0x08: "BPF_H", 0x10: "BPF_B", 0x18: "BPF_DW"}
+	modeNames  = map[uint8]string{0x00: "BPF_IMM", 0x20: "BPF_ABS", 0x40: "BPF_IND", 0x60: "BPF_MEM", 0x80: "BPF_MEMSX", 0xc0: "BPF_ATOMIC"}
+	aluNames   = map[uint8]string{
+		0x00: "BPF_ADD", 0x10: "BPF_SUB", 0x20: "BPF_MUL", 0x30: "BPF_DIV", 0x40: "BPF_OR", 0x50: "BPF_AND",
+		0x60: "BPF_LSH", 0x70: "BPF_RSH", 0x80: "BPF_NEG", 0x90: "BPF_MOD", 0xa0: "BPF_XOR", 0xb0: "BPF_MOV",
+		0xc0: "BPF_ARSH", 0xd0: "BPF_END",
+	}
+	jumpNames = map[uint8]string{
+		0x00: "BPF_JA", 0x10: "BPF_JEQ", 0x20: "BPF_JGT", 0x30: "BPF_JGE", 0x40: "BPF_JSET", 0x50: "BPF_JNE",
+		0x60: "BPF_JSGT", 0x70: "BPF_JSGE", 0x80: "BPF_CALL", 0x90: "BPF_EXIT", 0xa0: "BPF_JLT", 0xb0: "BPF_JLE",
+		0xc0: "BPF_JSLT", 0xd0: "BPF_JSLE",
+	}
+)
+
+func reg(r uint8) string {
+	if r > 10 {
+		return fmt.Sprint(r)
+	}
+	return fmt.Sprintf("BPF_REG_%d", r)
+}
+
+// codeName writes the opcode as the OR of its constants
+func codeName(code uint8) string {
+	class := code & 0x07
+	parts := []string{classNames[class]}
+	switch class {
+	case classALU, classALU64, classJMP, classJMP32:
+		names := aluNames
+		if class == classJMP || class == classJMP32 {
+			names = jumpNames
+		}
+		parts = append(parts, names[code&0xf0])
+		if code&sourceX != 0 {
+			parts = append(parts, "BPF_X")
+		} else {
+			parts = append(parts, "BPF_K")
+		}
+	default:
+		parts = append(parts, sizeNames[code&0x18])
+		if mode, ok := modeNames[code&0xe0]; ok {
+			parts = append(parts, mode)
+		} else {
+			parts = append(parts, fmt.Sprintf("0x%02x", code&0xe0))
+		}
+	}
+	for _, p := range parts {
+		if p == "" {
+			return fmt.Sprintf("0x%02x", code)
+		}
+	}
+	return strings.Join(parts, " | ")
+}
+
+// atomicName writes the operation of an atomic instruction
+func atomicName(imm int32) string {
+	switch imm {
+	case 0xe1:
+		return "BPF_XCHG"
+	case 0xf1:
+		return "BPF_CMPXCHG"
+	}
+	name, ok := aluNames[uint8(imm)&0xf0]
+	if !ok || imm&^0xf1 != 0 {
+		return fmt.Sprint(imm)
+	}
+	if imm&0x01 != 0 {
+		name += " | BPF_FETCH"
+	}
+	return name
+}
+
+func rawMacro(s rawInsn) string {
+	return fmt.Sprintf("BPF_RAW_INSN(%s, %s, %s, %d, %d)", codeName(s.code), reg(s.dst), reg(s.src), s.off, s.imm)
+}
+
+func slot(word instruction.Instruction) rawInsn {
+	return rawInsn{
+		code: uint8(word.Opcode()),
+		dst:  uint8(word.Regs().DstReg()),
+		src:  uint8(word.Regs().SrcReg()),
+		off:  int16(word.Offset()),
+		imm:  int32(word.Imm()),
+	}
+}
+
+// macroOf returns the most specific macro writing the instruction, falling
+// back to BPF_RAW_INSN
+func macroOf(ins instruction.Instruction, helpers *helper.Table) string {
+	s := slot(ins)
+	class, op := s.code&0x07, s.code&0xf0
+	size, mode := sizeNames[s.code&0x18], s.code&0xe0
+	x := s.code&sourceX != 0
+
+	switch class {
+	case classALU, classALU64:
+		bits := "64"
+		if class == classALU {
+			bits = "32"
+		}
+		name, ok := aluNames[op]
+		switch {
+		case !ok:
+		case op == opMOV && x && s.off == 0 && s.imm == 0:
+			return fmt.Sprintf("BPF_MOV%s_REG(%s, %s)", bits, reg(s.dst), reg(s.src))
+		case op == opMOV && x && s.imm == 0 && (s.off == 8 || s.off == 16 || s.off == 32):
+			return fmt.Sprintf("BPF_MOVSX%s_REG(%s, %s, %d)", bits, reg(s.dst), reg(s.src), s.off)
+		case op == opMOV && !x && s.off == 0 && s.src == 0:
+			return fmt.Sprintf("BPF_MOV%s_IMM(%s, %d)", bits, reg(s.dst), s.imm)
+		case op == opMOV && x && class == classALU && s.off == 0 && s.dst == s.src && s.imm == 1:
+			return fmt.Sprintf("BPF_ZEXT_REG(%s)", reg(s.dst))
+		case op == opMOV && s.off == 0:
+			source := "BPF_K"
+			if x {
+				source = "BPF_X"
+			}
+			return fmt.Sprintf("BPF_MOV%s_RAW(%s, %s, %s, %d)", bits, source, reg(s.dst), reg(s.src), s.imm)
+		case op == opEND && class == classALU && s.off == 0 && s.src == 0:
+			order := "BPF_TO_LE"
+			if x {
+				order = "BPF_TO_BE"
+			}
+			return fmt.Sprintf("BPF_ENDIAN(%s, %s, %d)", order, reg(s.dst), s.imm)
+		case op == opEND && !x && s.off == 0 && s.src == 0:
+			return fmt.Sprintf("BPF_BSWAP(%s, %d)", reg(s.dst), s.imm)
+		case op == opEND || op == opMOV || s.off != 0:
+			// signed divisions have no macro
+		case x && s.imm == 0:
+			return fmt.Sprintf("BPF_ALU%s_REG(%s, %s, %s)", bits, name, reg(s.dst), reg(s.src))
+		case !x && s.src == 0:
+			return fmt.Sprintf("BPF_ALU%s_IMM(%s, %s, %d)", bits, name, reg(s.dst), s.imm)
+		}
+	case classJMP, classJMP32:
+		bits := ""
+		if class == classJMP32 {
+			bits = "32"
+		}
+		name, ok := jumpNames[op]
+		switch {
+		case !ok:
+		case op == opEXIT && class == classJMP && s == (rawInsn{code: s.code}):
+			return "BPF_EXIT_INSN()"
+		case op == opCALL && class == classJMP && !x && s.dst == 0 && s.off == 0:
+			switch s.src {
+			case 0:
+				if h, ok := helpers.Lookup(s.imm); ok && strings.HasPrefix(h.Name, "bpf_") {
+					return fmt.Sprintf("BPF_EMIT_CALL(BPF_FUNC_%s)", strings.TrimPrefix(h.Name, "bpf_"))
+				}
+				return fmt.Sprintf("BPF_EMIT_CALL(%d)", s.imm)
+			case 1:
+				return fmt.Sprintf("BPF_CALL_REL(%d)", s.imm)
+			}
+		case op == 0 && class == classJMP && s == (rawInsn{code: s.code, off: s.off}):
+			return fmt.Sprintf("BPF_JMP_A(%d)", s.off)
+		case op == 0 && class == classJMP32 && s == (rawInsn{code: s.code, imm: s.imm}):
+			return fmt.Sprintf("BPF_JMP32_A(%d)", s.imm)
+		case op == 0 || op == opCALL || op == opEXIT:
+		case x && s.imm == 0:
+			return fmt.Sprintf("BPF_JMP%s_REG(%s, %s, %s, %d)", bits, name, reg(s.dst), reg(s.src), s.off)
+		case !x && s.src == 0:
+			return fmt.Sprintf("BPF_JMP%s_IMM(%s, %s, %d, %d)", bits, name, reg(s.dst), s.imm, s.off)
+		}
+	case classLD:
+		switch {
+		case mode == modeABS && s.dst == 0 && s.src == 0 && s.off == 0:
+			return fmt.Sprintf("BPF_LD_ABS(%s, %d)", size, s.imm)
+		case mode == modeIND && s.dst == 0 && s.off == 0:
+			return fmt.Sprintf("BPF_LD_IND(%s, %s, %d)", size, reg(s.src), s.imm)
+		}
+	case classLDX:
+		switch {
+		case mode == modeMEM && s.imm == 0:
+			return fmt.Sprintf("BPF_LDX_MEM(%s, %s, %s, %d)", size, reg(s.dst), reg(s.src), s.off)
+		case mode == modeMEMSX && s.imm == 0:
+			return fmt.Sprintf("BPF_LDX_MEMSX(%s, %s, %s, %d)", size, reg(s.dst), reg(s.src), s.off)
+		}
+	case classST:
+		if mode == modeMEM && s.src == 0 {
+			return fmt.Sprintf("BPF_ST_MEM(%s, %s, %d, %d)", size, reg(s.dst), s.off, s.imm)
+		}
+	case classSTX:
+		switch {
+		case mode == modeMEM && s.imm == 0:
+			return fmt.Sprintf("BPF_STX_MEM(%s, %s, %s, %d)", size, reg(s.dst), reg(s.src), s.off)
+		case mode == modeATOMIC && s.imm == 0:
+			return fmt.Sprintf("BPF_STX_XADD(%s, %s, %s, %d)", size, reg(s.dst), reg(s.src), s.off)
+		case mode == modeATOMIC:
+			return fmt.Sprintf("BPF_ATOMIC_OP(%s, %s, %s, %s, %d)", size, atomicName(s.imm), reg(s.dst), reg(s.src), s.off)
+		}
+	}
+	return rawMacro(s)
+}
+
+// imm64Macro writes a 64-bit immediate load, its two slots are written as raw
+// instructions when no macro fits. The loads relocated to a map are written
+// with BPF_LD_MAP_FD, like the loaders rewrite them.
+func imm64Macro(ins instruction.Instruction, reloc *program.Relocation) string {
+	first, second := slot(ins), slot(instruction.Instruction{Basic: ins.Pseudo, BigEndian: ins.BigEndian})
+	if first.code != codeLDDW || second != (rawInsn{off: second.off, imm: second.imm}) {
+		return rawMacro(first) + ",\n" + rawMacro(second)
+	}
+	if first.off != 0 || second.off != 0 {
+		return fmt.Sprintf("BPF_LD_IMM64_RAW_FULL(%s, %d, %d, %d, %d, %d)", reg(first.dst), first.src, first.off, second.off, first.imm, second.imm)
+	}
+	switch first.src {
+	case 0:
+		if reloc != nil && reloc.IsMap() && ins.Imm64() == 0 {
+			return fmt.Sprintf("BPF_LD_MAP_FD(%s, 0)", reg(first.dst))
+		}
+		return fmt.Sprintf("BPF_LD_IMM64(%s, %d)", reg(first.dst), ins.Imm64())
+	case 1:
+		if second.imm == 0 {
+			return fmt.Sprintf("BPF_LD_MAP_FD(%s, %d)", reg(first.dst), first.imm)
+		}
+	case 2:
+		return fmt.Sprintf("BPF_LD_MAP_VALUE(%s, %d, %d)", reg(first.dst), first.imm, second.imm)
+	}
+	return fmt.Sprintf("BPF_LD_IMM64_RAW(%s, %d, %d)", reg(first.dst), first.src, ins.Imm64())
+}
+
+// Write writes the program as struct bpf_insn macros, one instruction per
+// line. The relocated instructions are commented with their target symbol.
+func Write(w io.Writer, p program.Program) error {
+	helpers := p.Helpers
+	if helpers == nil {
+		helpers = helper.Default()
+	}
+	for _, ins := range p.Instructions {
+		var line string
+		if ins.Instruction.Extended64 {
+			line = imm64Macro(ins.Instruction, ins.Relocation)
+		} else {
+			line = macroOf(ins.Instruction, helpers)
+		}
+		line += ","
+		if ins.Relocation != nil {
+			line += fmt.Sprintf(" /* %s */", ins.Relocation.Symbol)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
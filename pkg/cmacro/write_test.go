@@ -0,0 +1,177 @@
+package cmacro
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+	"github.com/mtardy/mahebpf/pkg/program"
+)
+
+func newTestProgram(words ...uint64) program.Program {
+	prog := program.NewProgram()
+	for i := 0; i < len(words); i++ {
+		ins := instruction.NewInstruction(words[i])
+		number := i
+		if ins.NeedPseudoInstruction() {
+			i++
+			ins.AddPseudoInstruction(words[i])
+		}
+		prog.Instructions = append(prog.Instructions, program.ProgramInstruction{Instruction: ins, Number: number})
+	}
+	return prog
+}
+
+func TestWrite(t *testing.T) {
+	prog := newTestProgram(
+		0xb701000000000000,
+		0x631afcff00000000,
+		0x850000000e000000,
+		0xbfa2000000000000,
+		0x07020000fcffffff,
+		0x1801000000000000, 0x0000000000000000,
+		0x5500090000000000,
+		0xb403000000000000,
+		0xdc01000010000000,
+		0xbf21080000000000,
+		0x3f21010000000000,
+		0x0500010000000000,
+		0xc3120000e1000000,
+		0xd701000040000000,
+		0xdb12f8ff00000000,
+		0x9500000000000000,
+	)
+	prog.Instructions[5].Relocation = &program.Relocation{Symbol: "events", Section: ".maps"}
+
+	var out bytes.Buffer
+	if err := Write(&out, prog); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	want := `BPF_MOV64_IMM(BPF_REG_1, 0),
+BPF_STX_MEM(BPF_W, BPF_REG_10, BPF_REG_1, -4),
+BPF_EMIT_CALL(BPF_FUNC_get_current_pid_tgid),
+BPF_MOV64_REG(BPF_REG_2, BPF_REG_10),
+BPF_ALU64_IMM(BPF_ADD, BPF_REG_2, -4),
+BPF_LD_MAP_FD(BPF_REG_1, 0), /* events */
+BPF_JMP_IMM(BPF_JNE, BPF_REG_0, 0, 9),
+BPF_MOV32_IMM(BPF_REG_3, 0),
+BPF_ENDIAN(BPF_TO_BE, BPF_REG_1, 16),
+BPF_MOVSX64_REG(BPF_REG_1, BPF_REG_2, 8),
+BPF_RAW_INSN(BPF_ALU64 | BPF_DIV | BPF_X, BPF_REG_1, BPF_REG_2, 1, 0),
+BPF_JMP_A(1),
+BPF_ATOMIC_OP(BPF_W, BPF_XCHG, BPF_REG_2, BPF_REG_1, 0),
+BPF_BSWAP(BPF_REG_1, 64),
+BPF_STX_XADD(BPF_DW, BPF_REG_2, BPF_REG_1, -8),
+BPF_EXIT_INSN(),
+`
+	if out.String() != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteParse(t *testing.T) {
+	// every instruction must read back to the same bytes, even the ones
+	// written as raw instructions, and every macro must be written
+	prog := newTestProgram(
+		0xb701000000000000,
+		0xb403000000000000,
+		0xbfa2000000000000,
+		0xbc21000000000000,
+		0xbf21080000000000,
+		0xbc21080000000000,
+		0x0f21000000000000,
+		0x0c21000000000000,
+		0x0401000005000000,
+		0xdc01000010000000,
+		0x6112040000000000,
+		0x631afcff00000000,
+		0x3000000004000000,
+		0x5500090000000000,
+		0x1e21020000000000,
+		0x0500010000000000,
+		0x9500000000000000,
+		0x1812000003000000, 0x0000000000000000,
+		0x1822000003000000, 0x0000000008000000,
+		0x1863000001000000, 0x0000000008000000,
+		0x18010000ffffffff, 0x00000000ffffffff,
+		0x1801010000000000, 0x0000000000000000,
+		0x1801020000000000, 0x0000030000000000,
+		0x1841000005000000, 0x0000000001000000,
+		0x8510000003000000,
+		0x8520000003000000,
+		0x850000002c010000,
+		0x0600000000000100,
+		0xc6000200ffffffff,
+		0x3d12fdff00000000,
+		0x3020000004000000,
+		0x5020000004000000,
+		0x9102040000000000,
+		0xdb120000f1000000,
+		0xdb12000041000000,
+		0xd703000040000000,
+		0xd713000040000000,
+		0xbc11000001000000,
+		0xbf12000005000000,
+		0xb412000005000000,
+		0xc312080000000000,
+		0x8700000000000000,
+		0x7a0110002a000000,
+		0xe701000000000000,
+	)
+	var out bytes.Buffer
+	if err := Write(&out, prog); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := Parse(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("Parse() error = %v, for\n%s", err, out.String())
+	}
+	if len(got.Instructions) != len(prog.Instructions) {
+		t.Fatalf("Parse() got %d instructions, want %d, for\n%s", len(got.Instructions), len(prog.Instructions), out.String())
+	}
+	for i := range prog.Instructions {
+		if got.Instructions[i] != prog.Instructions[i] {
+			t.Errorf("instruction %d = %v, want %v", i, got.Instructions[i].Instruction, prog.Instructions[i].Instruction)
+		}
+	}
+	for name := range macros {
+		if !strings.Contains(out.String(), name+"(") {
+			t.Errorf("Write() never wrote %s", name)
+		}
+	}
+	if t.Failed() {
+		t.Logf("written as\n%s", out.String())
+	}
+}
+
+func TestWriteParseMapLoad(t *testing.T) {
+	// the map loads read back as the map fd loads of the loaders
+	prog := newTestProgram(
+		0x1801000000000000, 0x0000000000000000,
+		0x1802000000000000, 0x0000000000000000,
+	)
+	prog.Instructions[0].Relocation = &program.Relocation{Symbol: "events", Section: ".maps"}
+	prog.Instructions[1].Relocation = &program.Relocation{Symbol: "counter", Section: ".bss"}
+
+	var out bytes.Buffer
+	if err := Write(&out, prog); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := Parse(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("Parse() error = %v, for\n%s", err, out.String())
+	}
+	want := newTestProgram(
+		0x1811000000000000, 0x0000000000000000,
+		0x1802000000000000, 0x0000000000000000,
+	)
+	if len(got.Instructions) != len(want.Instructions) {
+		t.Fatalf("Parse() got %d instructions, want %d, for\n%s", len(got.Instructions), len(want.Instructions), out.String())
+	}
+	for i := range want.Instructions {
+		if got.Instructions[i].Instruction != want.Instructions[i].Instruction {
+			t.Errorf("instruction %d = %v, want %v, for\n%s", i, got.Instructions[i].Instruction, want.Instructions[i].Instruction, out.String())
+		}
+	}
+}
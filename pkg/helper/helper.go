@@ -0,0 +1,119 @@
+package helper
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Helper describes a kernel helper function, as listed in the bpf_func_id
+// enum of the kernel uapi headers.
+type Helper struct {
+	ID     int32  `json:"id"`
+	Name   string `json:"name"`
+	Return string `json:"return"`
+	// Args are the C declarations of the arguments, a trailing "..." marks a
+	// variadic helper
+	Args    []string `json:"args"`
+	GPLOnly bool     `json:"gpl_only"`
+	// Since is the first kernel version shipping the helper
+	Since string `json:"since"`
+}
+
+// Prototype returns the C declaration of the helper
+func (h Helper) Prototype() string {
+	args := "void"
+	if len(h.Args) > 0 {
+		args = strings.Join(h.Args, ", ")
+	}
+	ret := h.Return
+	if !strings.HasSuffix(ret, "*") {
+		ret += " "
+	}
+	return fmt.Sprintf("%s%s(%s)", ret, h.Name, args)
+}
+
+// Variadic reports whether the helper takes a variable number of arguments
+func (h Helper) Variadic() bool {
+	return len(h.Args) > 0 && h.Args[len(h.Args)-1] == "..."
+}
+
+// Table indexes helpers by ID and by name
+type Table struct {
+	byID   map[int32]Helper
+	byName map[string]Helper
+}
+
+// NewTable indexes the helpers, it returns an error on duplicated IDs or
+// names.
+func NewTable(helpers []Helper) (*Table, error) {
+	t := &Table{
+		byID:   make(map[int32]Helper, len(helpers)),
+		byName: make(map[string]Helper, len(helpers)),
+	}
+	for _, h := range helpers {
+		if h.Name == "" {
+			return nil, fmt.Errorf("helper %d has no name", h.ID)
+		}
+		if _, ok := t.byID[h.ID]; ok {
+			return nil, fmt.Errorf("helper %d is defined twice", h.ID)
+		}
+		if _, ok := t.byName[h.Name]; ok {
+			return nil, fmt.Errorf("helper %q is defined twice", h.Name)
+		}
+		t.byID[h.ID] = h
+		t.byName[h.Name] = h
+	}
+	return t, nil
+}
+
+// Lookup returns the helper with the given ID
+func (t *Table) Lookup(id int32) (Helper, bool) {
+	h, ok := t.byID[id]
+	return h, ok
+}
+
+// LookupName returns the helper with the given name
+func (t *Table) LookupName(name string) (Helper, bool) {
+	h, ok := t.byName[name]
+	return h, ok
+}
+
+// Load reads a JSON array of helpers, in the format of the default table
+func Load(r io.Reader) (*Table, error) {
+	var helpers []Helper
+	if err := json.NewDecoder(r).Decode(&helpers); err != nil {
+		return nil, fmt.Errorf("failed to decode the helper table: %w", err)
+	}
+	return NewTable(helpers)
+}
+
+func FromFile(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+//go:embed helpers.json
+var defaultHelpers string
+
+var loadDefault = sync.OnceValue(func() *Table {
+	t, err := Load(strings.NewReader(defaultHelpers))
+	if err != nil {
+		panic("the embedded helper table is invalid: " + err.Error())
+	}
+	return t
+})
+
+// Default returns the table of the helpers known upstream, up to
+// bpf_cgrp_storage_delete of Linux 6.2.
+func Default() *Table {
+	return loadDefault()
+}
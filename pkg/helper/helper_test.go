@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	table := Default()
+
+	h, ok := table.Lookup(14)
+	if !ok || h.Name != "bpf_get_current_pid_tgid" {
+		t.Fatalf("Lookup(14) = %+v, %v, want bpf_get_current_pid_tgid", h, ok)
+	}
+	h, ok = table.LookupName("bpf_map_update_elem")
+	if !ok || h.ID != 2 {
+		t.Fatalf("LookupName(bpf_map_update_elem) = %+v, %v, want ID 2", h, ok)
+	}
+	if _, ok := table.Lookup(0); ok {
+		t.Error("Lookup(0) found a helper for BPF_FUNC_unspec")
+	}
+	if h, _ := table.Lookup(6); !h.GPLOnly || !h.Variadic() {
+		t.Errorf("bpf_trace_printk should be GPL only and variadic, got %+v", h)
+	}
+}
+
+func TestPrototype(t *testing.T) {
+	tests := []struct {
+		id   int32
+		want string
+	}{
+		{id: 1, want: "void *bpf_map_lookup_elem(struct bpf_map *map, const void *key)"},
+		{id: 14, want: "u64 bpf_get_current_pid_tgid(void)"},
+		{id: 6, want: "long bpf_trace_printk(const char *fmt, u32 fmt_size, ...)"},
+	}
+	for _, tt := range tests {
+		h, ok := Default().Lookup(tt.id)
+		if !ok {
+			t.Fatalf("Lookup(%d) found no helper", tt.id)
+		}
+		if got := h.Prototype(); got != tt.want {
+			t.Errorf("Prototype() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	table, err := Load(strings.NewReader(`[{"id": 300, "name": "bpf_from_the_future", "return": "long", "args": ["u64 flags"], "since": "9.9"}]`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if h, ok := table.Lookup(300); !ok || h.Name != "bpf_from_the_future" {
+		t.Errorf("Lookup(300) = %+v, %v, want bpf_from_the_future", h, ok)
+	}
+
+	for _, input := range []string{
+		`{"id": 1}`,
+		`[{"id": 1, "name": "a"}, {"id": 1, "name": "b"}]`,
+		`[{"id": 1, "name": "a"}, {"id": 2, "name": "a"}]`,
+		`[{"id": 1}]`,
+	} {
+		if _, err := Load(strings.NewReader(input)); err == nil {
+			t.Errorf("Load(%s) expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package instruction
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
+)
+
+// bpftoolSyntax follows print_bpf_insn of the kernel, used by bpftool prog
+// dump xlated and the verifier log. The jump offsets are relative to pc, the
+// next instruction, and the offsets of memory accesses are always signed.
+type bpftoolSyntax struct{}
+
+func (bpftoolSyntax) Name() string {
+	return "bpftool"
+}
+
+func (bpftoolSyntax) Format(d Decoded, opts DisassembleOptions) string {
+	return fmt.Sprintf("(%02x) %s", uint8(d.Instruction.Opcode()), bpftoolText(d, opts))
+}
+
+func (bpftoolSyntax) FormatSymbol(d Decoded, symbol string, isMap bool) string {
+	value := symbol
+	switch offset := d.Operands[1].Imm; {
+	case isMap:
+		value = fmt.Sprintf("map[%s]", symbol)
+	case offset != 0:
+		value = fmt.Sprintf("%s%+d", symbol, offset)
+	}
+	return fmt.Sprintf("(%02x) %s = %s", uint8(d.Instruction.Opcode()), d.Operands[0].Reg, value)
+}
+
+func (bpftoolSyntax) FormatCall(d Decoded, h helper.Helper, args []string) string {
+	return fmt.Sprintf("(%02x) %s(%s)", uint8(d.Instruction.Opcode()), bpftoolCall(d.Operands[0], h.Name), strings.Join(args, ", "))
+}
+
+func bpftoolText(d Decoded, opts DisassembleOptions) string {
+	switch d.Kind {
+	case KindArithmetic:
+		dst := registerName(d.Operands[0])
+		if d.Operation == "neg" {
+			return fmt.Sprintf("%s = -%s", dst, dst)
+		}
+		return fmt.Sprintf("%s %s= %s", dst, arithmeticOperators[d.Operation], bpftoolOperand(d.Operands[1]))
+	case KindMove:
+		dst, src := d.Operands[0], d.Operands[1]
+		if src.Signed {
+			return fmt.Sprintf("%s = (s%d)%s", registerName(dst), sizeBits(src.Size), registerName(src))
+		}
+		return fmt.Sprintf("%s = %s", registerName(dst), bpftoolOperand(src))
+	case KindByteSwap:
+		return disassembleByteSwap(d)
+	case KindLoad, KindStore:
+		return fmt.Sprintf("%s = %s", bpftoolOperand(d.Operands[0]), bpftoolOperand(d.Operands[1]))
+	case KindAtomic:
+		return bpftoolAtomic(d)
+	case KindLoadImm64:
+		return fmt.Sprintf("%s = %s", d.Operands[0].Reg, bpftoolImm64(d))
+	case KindLoadPacket:
+		return fmt.Sprintf("%s = %s", BPF_R0, bpftoolOperand(d.Operands[0]))
+	case KindJump:
+		if d.Width == 32 {
+			return fmt.Sprintf("gotol pc%+d", d.Operands[0].Offset)
+		}
+		return fmt.Sprintf("goto pc%+d", d.Operands[0].Offset)
+	case KindBranch:
+		src := bpftoolOperand(d.Operands[1])
+		if d.Operands[1].Kind == OperandImmediate {
+			src = fmt.Sprintf("0x%x", uint32(d.Operands[1].Imm))
+		}
+		return fmt.Sprintf("if %s %s %s goto pc%+d", registerName(d.Operands[0]), jumpOperators[d.Operation], src, d.Operands[2].Offset)
+	case KindCall:
+		name, _ := helperName(d.Operands[0], opts)
+		return bpftoolCall(d.Operands[0], name)
+	case KindExit:
+		return "exit"
+	default:
+		return buggyCase
+	}
+}
+
+// bpftoolOperand renders the registers, the immediates and the memory and
+// packet references
+func bpftoolOperand(o Operand) string {
+	switch o.Kind {
+	case OperandRegister:
+		return registerName(o)
+	case OperandMemory:
+		if o.Signed {
+			return fmt.Sprintf("*(s%d *)(%s %+d)", sizeBits(o.Size), o.Reg, o.Offset)
+		}
+		return fmt.Sprintf("*(%s *)(%s %+d)", o.Size, o.Reg, o.Offset)
+	case OperandPacket:
+		if o.Indirect {
+			return fmt.Sprintf("*(%s *)skb[%s + %d]", o.Size, o.Reg, o.Imm)
+		}
+		return fmt.Sprintf("*(%s *)skb[%d]", o.Size, o.Imm)
+	default:
+		return fmt.Sprint(o.Imm)
+	}
+}
+
+// bpftoolCall renders a call, name is the one of the called helper
+func bpftoolCall(call Operand, name string) string {
+	switch call.Kind {
+	case OperandHelper:
+		if name == "" {
+			name = "unknown"
+		}
+		return fmt.Sprintf("call %s#%d", name, call.Imm)
+	case OperandTarget:
+		return fmt.Sprintf("call pc%+d", call.Offset)
+	default:
+		return fmt.Sprintf("call kernel-function#%d", call.Imm)
+	}
+}
+
+func bpftoolImm64(d Decoded) string {
+	imm := d.Operands[1]
+	switch imm.Pseudo {
+	case BPF_IMM1:
+		return fmt.Sprintf("map[id:%d]", imm.Imm)
+	case BPF_IMM2:
+		return fmt.Sprintf("map[id:%d][0]+%d", imm.Imm, imm.Offset)
+	case BPF_IMM5:
+		return fmt.Sprintf("map[idx:%d]", imm.Imm)
+	case BPF_IMM6:
+		return fmt.Sprintf("map[idx:%d][0]+%d", imm.Imm, imm.Offset)
+	default:
+		// the kernel prints the whole 64 bits of the other pseudo values
+		return fmt.Sprintf("0x%x", uint64(d.Instruction.Imm64()))
+	}
+}
+
+func bpftoolAtomic(d Decoded) string {
+	mem, src := d.Operands[0], d.Operands[1].Reg
+	bits := ""
+	if d.Width == 64 {
+		bits = "64"
+	}
+	ptr := fmt.Sprintf("(%s *)(%s %+d)", mem.Size, mem.Reg, mem.Offset)
+
+	switch d.Operation {
+	case "xchg":
+		return fmt.Sprintf("%s = atomic%s_xchg(%s, %s)", src, bits, ptr, src)
+	case "cmpxchg":
+		return fmt.Sprintf("%s = atomic%s_cmpxchg(%s, %s, %s)", BPF_R0, bits, ptr, BPF_R0, src)
+	}
+	if strings.HasPrefix(d.Operation, "fetch_") {
+		return fmt.Sprintf("%s = atomic%s_%s(%s, %s)", src, bits, d.Operation, ptr, src)
+	}
+	return fmt.Sprintf("lock *%s %s= %s", ptr, arithmeticOperators[d.Operation], src)
+}
@@ -0,0 +1,750 @@
+package instruction
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kind is the kind of operation of a decoded instruction
+type Kind uint8
+
+const (
+	// dst op= src, or dst = -dst for the negation
+	KindArithmetic Kind = iota
+	// dst = src, sign-extending src for movsx
+	KindMove
+	// dst = bswap(dst), the byte order conversions
+	KindByteSwap
+	// dst = *(size *)(src + offset)
+	KindLoad
+	// *(size *)(dst + offset) = src
+	KindStore
+	// the atomic read-modify-write operations on *(size *)(dst + offset)
+	KindAtomic
+	// dst = imm64, an integer or a pseudo value like a map
+	KindLoadImm64
+	// r0 = *(size *)skb[src + imm], the legacy packet access of cBPF
+	KindLoadPacket
+	// goto target
+	KindJump
+	// if dst op src goto target
+	KindBranch
+	// the calls to helpers, kernel functions and local functions
+	KindCall
+	KindExit
+)
+
+var kindNames = [...]string{"arithmetic", "move", "byte swap", "load", "store", "atomic", "load imm64", "load packet", "jump", "branch", "call", "exit"}
+
+func (k Kind) String() string {
+	if int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", k)
+	}
+	return kindNames[k]
+}
+
+// OperandKind is the type of an operand of a decoded instruction
+type OperandKind uint8
+
+const (
+	// a register, or its lower 32 bits
+	OperandRegister OperandKind = iota
+	// an immediate value, or the 64-bit immediate of a load imm64
+	OperandImmediate
+	// a memory reference, a base register plus an offset
+	OperandMemory
+	// a legacy packet reference, skb[imm] or skb[reg + imm]
+	OperandPacket
+	// the target of a jump or of a local call
+	OperandTarget
+	// the ID of a called helper
+	OperandHelper
+	// the BTF ID of a called kernel function
+	OperandKfunc
+)
+
+var operandKindNames = [...]string{"register", "immediate", "memory", "packet", "target", "helper", "kfunc"}
+
+func (k OperandKind) String() string {
+	if int(k) >= len(operandKindNames) {
+		return fmt.Sprintf("OperandKind(%d)", k)
+	}
+	return operandKindNames[k]
+}
+
+// Operand is an operand of a decoded instruction, only the fields of its kind
+// are set
+type Operand struct {
+	Kind OperandKind
+	// Reg is the register, or the base register of a memory reference or of
+	// an indirect packet reference
+	Reg Register
+	// Sub is set for the 32-bit subregisters
+	Sub bool
+	// Imm is the value of an immediate, the ID of a helper or a kernel
+	// function, or the offset of a packet reference
+	Imm int64
+	// Pseudo is what a 64-bit immediate holds, BPF_IMM0 for an integer and
+	// a map or a variable for the others
+	Pseudo ImmSource
+	// Offset is the offset of a memory reference from its base, of a target
+	// from the next instruction, into the map value of the BPF_IMM2 and
+	// BPF_IMM6 immediates, or the module BTF of a kernel function
+	Offset int32
+	// Size is the size of a memory or packet reference, or of the part of a
+	// register that is sign-extended
+	Size OpcodeSize
+	// Signed is set for the sign-extended memory references and registers
+	Signed bool
+	// Indirect is set for the packet references relative to Reg
+	Indirect bool
+}
+
+func registerOperand(r Register, sub bool) Operand {
+	return Operand{Kind: OperandRegister, Reg: r, Sub: sub}
+}
+
+func immediateOperand(imm int64) Operand {
+	return Operand{Kind: OperandImmediate, Imm: imm}
+}
+
+func memoryOperand(base Register, offset Offset, size OpcodeSize) Operand {
+	return Operand{Kind: OperandMemory, Reg: base, Offset: int32(offset), Size: size}
+}
+
+func targetOperand(offset int32) Operand {
+	return Operand{Kind: OperandTarget, Offset: offset}
+}
+
+// Decoded is an instruction decoded into its operation and typed operands,
+// the syntaxes are rendered from it
+type Decoded struct {
+	Instruction Instruction
+	Kind        Kind
+	// Operation names the operation regardless of its width, like add, sdiv,
+	// movsx, bswap, ldx, fetch_add, jeq or call
+	Operation string
+	// Width is the number of bits the arithmetic, byte swap, atomic and jump
+	// operations work on, 0 for the others
+	Width int
+	// Operands are in the order of the ubpf syntax, the destination first
+	Operands []Operand
+}
+
+// sizeBits returns the number of bits of a memory access size
+func sizeBits(size OpcodeSize) int {
+	switch size {
+	case BPF_B:
+		return 8
+	case BPF_H:
+		return 16
+	case BPF_W:
+		return 32
+	default:
+		return 64
+	}
+}
+
+// sizeSuffixes are the suffixes of the load and store mnemonics
+var sizeSuffixes = map[OpcodeSize]string{
+	BPF_B:  "b",
+	BPF_H:  "h",
+	BPF_W:  "w",
+	BPF_DW: "dw",
+}
+
+// Mnemonic is the name of the operation along with its width or size, like
+// add64, ldxw or jeq32, as written in the ubpf syntax. The operations ubpf
+// does not have are named like its own ones, see UbpfSyntax.
+func (d Decoded) Mnemonic() string {
+	switch d.Kind {
+	case KindArithmetic:
+		return fmt.Sprintf("%s%d", d.Operation, d.Width)
+	case KindAtomic:
+		// ubpf only has the add of BPF_XADD, stxxaddw, the other atomic
+		// operations are named after the kernel ones in the same way
+		name := strings.ReplaceAll(d.Operation, "_", "")
+		if name == "add" {
+			name = "xadd"
+		}
+		return "stx" + name + sizeSuffixes[d.Operands[0].Size]
+	case KindMove:
+		if src := d.Operands[1]; src.Signed {
+			return fmt.Sprintf("%s%d_%d", d.Operation, sizeBits(src.Size), d.Width)
+		}
+		return fmt.Sprintf("%s%d", d.Operation, d.Width)
+	case KindByteSwap:
+		return fmt.Sprintf("%s%d", d.Operation, d.Width)
+	case KindLoad, KindLoadPacket:
+		return d.Operation + sizeSuffixes[d.Operands[len(d.Operands)-1].Size]
+	case KindStore:
+		return d.Operation + sizeSuffixes[d.Operands[0].Size]
+	case KindJump:
+		if d.Width == 32 {
+			return "gotol"
+		}
+		return d.Operation
+	case KindBranch:
+		if d.Width == 32 {
+			return d.Operation + "32"
+		}
+		return d.Operation
+	default:
+		return d.Operation
+	}
+}
+
+// Reads returns the registers whose value the instruction uses, including the
+// implicit ones like the arguments of calls or r0 of exit
+func (d Decoded) Reads() []Register {
+	var regs []Register
+	for i, o := range d.Operands {
+		switch {
+		case o.Kind == OperandMemory, o.Kind == OperandPacket && o.Indirect:
+			regs = append(regs, o.Reg)
+		case o.Kind != OperandRegister:
+		case i > 0, d.Kind != KindMove && d.Kind != KindLoad && d.Kind != KindLoadImm64:
+			// the destination is also read by the operations updating it
+			regs = append(regs, o.Reg)
+		}
+	}
+
+	switch d.Kind {
+	case KindAtomic:
+		if d.Operation == "cmpxchg" {
+			regs = append(regs, BPF_R0)
+		}
+	case KindLoadPacket:
+		// the packet is the one of the skb held by r6
+		regs = append(regs, BPF_R6)
+	case KindCall:
+		// the number of arguments depends on the function called
+		regs = append(regs, BPF_R1, BPF_R2, BPF_R3, BPF_R4, BPF_R5)
+	case KindExit:
+		regs = append(regs, BPF_R0)
+	}
+	return uniqueRegisters(regs)
+}
+
+// Writes returns the registers the instruction modifies, including the
+// registers clobbered by calls and legacy packet accesses
+func (d Decoded) Writes() []Register {
+	var regs []Register
+	switch d.Kind {
+	case KindArithmetic, KindMove, KindByteSwap, KindLoad, KindLoadImm64:
+		regs = append(regs, d.Operands[0].Reg)
+	case KindAtomic:
+		switch {
+		case d.Operation == "cmpxchg":
+			regs = append(regs, BPF_R0)
+		case d.Operation == "xchg", atomicFetch(d.Instruction):
+			regs = append(regs, d.Operands[1].Reg)
+		}
+	case KindLoadPacket, KindCall:
+		// r0 holds the result and the caller-saved registers are scratched
+		regs = append(regs, BPF_R0, BPF_R1, BPF_R2, BPF_R3, BPF_R4, BPF_R5)
+	}
+	return uniqueRegisters(regs)
+}
+
+func uniqueRegisters(regs []Register) []Register {
+	sort.Slice(regs, func(i, j int) bool { return regs[i] < regs[j] })
+	unique := regs[:0]
+	for i, r := range regs {
+		if i == 0 || r != regs[i-1] {
+			unique = append(unique, r)
+		}
+	}
+	return unique
+}
+
+// Decode decodes the instruction into its operation and operands, it returns
+// a DecodeError for the encodings it cannot decode
+func (ins Instruction) Decode() (Decoded, error) {
+	typedOpcode, err := ins.Opcode().ToTyped()
+	if err != nil {
+		return Decoded{}, decodeErrorf(ins, "%s", err)
+	}
+	var d Decoded
+	switch op := typedOpcode.(type) {
+	case ArithmeticOpcode:
+		d, err = decodeArithmetic(op, ins)
+	case JumpOpcode:
+		d, err = decodeJump(op, ins)
+	case LoadAndStoreOpcode:
+		d, err = decodeLoadAndStore(op, ins)
+	default:
+		return Decoded{}, decodeErrorf(ins, buggyCase)
+	}
+	if err != nil {
+		return Decoded{}, err
+	}
+	for _, o := range d.Operands {
+		usesRegister := o.Kind == OperandRegister || o.Kind == OperandMemory || o.Kind == OperandPacket && o.Indirect
+		if usesRegister && o.Reg.Validate() != nil {
+			return Decoded{}, invalidRegister(ins, o.Reg)
+		}
+	}
+	d.Instruction = ins
+	return d, nil
+}
+
+func invalidRegister(ins Instruction, r Register) *DecodeError {
+	return decodeErrorf(ins, "%s", r.Validate())
+}
+
+// field is an instruction field, that some operations leave unused
+type field uint8
+
+const (
+	fieldDst field = iota
+	fieldSrc
+	fieldOffset
+	fieldImm
+)
+
+var fieldNames = [...]string{"dst_reg", "src_reg", "offset", "imm"}
+
+func (f field) value(ins Instruction) int64 {
+	switch f {
+	case fieldDst:
+		return int64(ins.Regs().DstReg())
+	case fieldSrc:
+		return int64(ins.Regs().SrcReg())
+	case fieldOffset:
+		return int64(ins.Offset())
+	default:
+		return int64(ins.Imm())
+	}
+}
+
+// unused returns a DecodeError when one of the fields the operation does not
+// use is set, as the rendered instruction would not tell these bits
+func unused(ins Instruction, operation string, fields ...field) error {
+	for _, f := range fields {
+		if v := f.value(ins); v != 0 {
+			return decodeErrorf(ins, "%s does not use the %s field, got %d", operation, fieldNames[f], v)
+		}
+	}
+	return nil
+}
+
+// unusedBySource checks the field the source leaves unused, src_reg for an
+// immediate and imm for a register
+func unusedBySource(ins Instruction, operation string, source OpcodeSource) error {
+	if source == BPF_X {
+		return unused(ins, operation, fieldImm)
+	}
+	return unused(ins, operation, fieldSrc)
+}
+
+var arithmeticOperations = map[OpcodeArithmetic]string{
+	BPF_ADD:  "add",
+	BPF_SUB:  "sub",
+	BPF_MUL:  "mul",
+	BPF_DIV:  "div",
+	BPF_OR:   "or",
+	BPF_AND:  "and",
+	BPF_LSH:  "lsh",
+	BPF_RSH:  "rsh",
+	BPF_NEG:  "neg",
+	BPF_MOD:  "mod",
+	BPF_XOR:  "xor",
+	BPF_MOV:  "mov",
+	BPF_ARSH: "arsh",
+}
+
+// sourceOperand returns the register or the immediate source of an arithmetic
+// or a jump instruction
+func sourceOperand(source OpcodeSource, ins Instruction, sub bool) Operand {
+	if source == BPF_X {
+		return registerOperand(ins.Regs().SrcReg(), sub)
+	}
+	return immediateOperand(int64(ins.Imm()))
+}
+
+func decodeArithmetic(op ArithmeticOpcode, ins Instruction) (Decoded, error) {
+	d := Decoded{Kind: KindArithmetic, Width: 64}
+	// BPF_ALU operates on the 32-bit subregisters
+	sub := ins.Opcode().Class() == BPF_ALU
+	if sub {
+		d.Width = 32
+	}
+	dst := registerOperand(ins.Regs().DstReg(), sub)
+
+	switch op.Code() {
+	case BPF_END:
+		return decodeByteSwap(op, ins)
+	case BPF_MOV:
+		return decodeMove(op, ins, d, dst)
+	case BPF_NEG:
+		if op.Source() != BPF_K {
+			return Decoded{}, decodeErrorf(ins, "neg does not support the BPF_X source")
+		}
+		if err := unused(ins, "neg", fieldSrc, fieldOffset, fieldImm); err != nil {
+			return Decoded{}, err
+		}
+		d.Operation = "neg"
+		d.Operands = []Operand{dst}
+		return d, nil
+	case BPF_DIV, BPF_MOD:
+		// ISA v4 made them signed when offset is 1
+		switch ins.Offset() {
+		case 0:
+			d.Operation = arithmeticOperations[op.Code()]
+		case 1:
+			d.Operation = "s" + arithmeticOperations[op.Code()]
+		default:
+			return Decoded{}, decodeErrorf(ins, "%s does not support the %d offset", op.Code(), ins.Offset())
+		}
+	default:
+		name, ok := arithmeticOperations[op.Code()]
+		if !ok {
+			return Decoded{}, decodeErrorf(ins, "unknown arithmetic operation 0x%02x", uint8(op.Code()))
+		}
+		d.Operation = name
+		if err := unused(ins, name, fieldOffset); err != nil {
+			return Decoded{}, err
+		}
+	}
+	if err := unusedBySource(ins, d.Operation, op.Source()); err != nil {
+		return Decoded{}, err
+	}
+	// the verifier rejects the immediate shifts out of the width, that the
+	// syntaxes would render masked
+	shift := op.Code() == BPF_LSH || op.Code() == BPF_RSH || op.Code() == BPF_ARSH
+	if shift && op.Source() == BPF_K && (ins.Imm() < 0 || int(ins.Imm()) >= d.Width) {
+		return Decoded{}, decodeErrorf(ins, "%s%d does not support the %d bits shift", d.Operation, d.Width, ins.Imm())
+	}
+	d.Operands = []Operand{dst, sourceOperand(op.Source(), ins, sub)}
+	return d, nil
+}
+
+// decodeMove decodes the moves, that ISA v4 made sign-extending from the
+// number of bits in offset
+func decodeMove(op ArithmeticOpcode, ins Instruction, d Decoded, dst Operand) (Decoded, error) {
+	d.Kind = KindMove
+	d.Operation = "mov"
+	if err := unusedBySource(ins, d.Operation, op.Source()); err != nil {
+		return Decoded{}, err
+	}
+	src := sourceOperand(op.Source(), ins, dst.Sub)
+	d.Operands = []Operand{dst, src}
+	if ins.Offset() == 0 {
+		return d, nil
+	}
+
+	if op.Source() != BPF_X {
+		return Decoded{}, decodeErrorf(ins, "sign-extension move does not support the BPF_K source")
+	}
+	switch {
+	case ins.Offset() == 8:
+		src.Size = BPF_B
+	case ins.Offset() == 16:
+		src.Size = BPF_H
+	case ins.Offset() == 32 && ins.Opcode().Class() == BPF_ALU64:
+		src.Size = BPF_W
+	default:
+		return Decoded{}, decodeErrorf(ins, "sign-extension move with %s does not support the %d bits width", ins.Opcode().Class(), ins.Offset())
+	}
+	src.Signed = true
+	d.Operation = "movsx"
+	d.Operands[1] = src
+	return d, nil
+}
+
+func decodeByteSwap(op ArithmeticOpcode, ins Instruction) (Decoded, error) {
+	d := Decoded{Kind: KindByteSwap, Operands: []Operand{registerOperand(ins.Regs().DstReg(), false)}}
+	switch {
+	case ins.Opcode().Class() == BPF_ALU64:
+		// ALU64 byte swaps are unconditional, the source bit is reserved
+		if op.Source() != BPF_TO_LE {
+			return Decoded{}, decodeErrorf(ins, "byte swap with BPF_ALU64 does not support the 0x%02x source", op.Source())
+		}
+		d.Operation = "bswap"
+	case op.Source() == BPF_TO_BE:
+		d.Operation = "be"
+	default:
+		d.Operation = "le"
+	}
+
+	if err := unused(ins, d.Operation, fieldSrc, fieldOffset); err != nil {
+		return Decoded{}, err
+	}
+	switch ins.Imm() {
+	case 16, 32, 64:
+		d.Width = int(ins.Imm())
+		return d, nil
+	default:
+		return Decoded{}, decodeErrorf(ins, "byte swap does not support the %d bits width", ins.Imm())
+	}
+}
+
+var jumpOperations = map[OpcodeJump]string{
+	BPF_JEQ:  "jeq",
+	BPF_JGT:  "jgt",
+	BPF_JGE:  "jge",
+	BPF_JSET: "jset",
+	BPF_JNE:  "jne",
+	BPF_JSGT: "jsgt",
+	BPF_JSGE: "jsge",
+	BPF_JLT:  "jlt",
+	BPF_JLE:  "jle",
+	BPF_JSLT: "jslt",
+	BPF_JSLE: "jsle",
+}
+
+func decodeJump(op JumpOpcode, ins Instruction) (Decoded, error) {
+	jmp32 := ins.Opcode().Class() == BPF_JMP32
+	switch op.Code() {
+	case BPF_JA:
+		if jmp32 {
+			// the long jump of ISA v4 stores its target in imm instead of
+			// offset to reach beyond the 16-bit range
+			if op.Source() != BPF_K {
+				return Decoded{}, decodeErrorf(ins, "long jump does not support the BPF_X source")
+			}
+			if err := unused(ins, "gotol", fieldDst, fieldSrc, fieldOffset); err != nil {
+				return Decoded{}, err
+			}
+			return Decoded{Kind: KindJump, Operation: "ja", Width: 32, Operands: []Operand{targetOperand(int32(ins.Imm()))}}, nil
+		}
+		if op.Source() != BPF_K {
+			return Decoded{}, decodeErrorf(ins, "jump does not support the BPF_X source")
+		}
+		if err := unused(ins, "ja", fieldDst, fieldSrc, fieldImm); err != nil {
+			return Decoded{}, err
+		}
+		return Decoded{Kind: KindJump, Operation: "ja", Width: 64, Operands: []Operand{targetOperand(int32(ins.Offset()))}}, nil
+	case BPF_CALL, BPF_EXIT:
+		if jmp32 {
+			return Decoded{}, decodeErrorf(ins, "%s does not support the %s class", op.Code(), BPF_JMP32)
+		}
+		if op.Code() == BPF_EXIT {
+			if op.Source() != BPF_K {
+				return Decoded{}, decodeErrorf(ins, "exit does not support the BPF_X source")
+			}
+			if err := unused(ins, "exit", fieldDst, fieldSrc, fieldOffset, fieldImm); err != nil {
+				return Decoded{}, err
+			}
+			return Decoded{Kind: KindExit, Operation: "exit"}, nil
+		}
+		return decodeCall(op, ins)
+	}
+
+	name, ok := jumpOperations[op.Code()]
+	if !ok {
+		return Decoded{}, decodeErrorf(ins, "unknown jump operation 0x%x", uint8(op.Code()))
+	}
+	if err := unusedBySource(ins, name, op.Source()); err != nil {
+		return Decoded{}, err
+	}
+	d := Decoded{Kind: KindBranch, Operation: name, Width: 64}
+	if jmp32 {
+		d.Width = 32
+	}
+	d.Operands = []Operand{
+		registerOperand(ins.Regs().DstReg(), jmp32),
+		sourceOperand(op.Source(), ins, jmp32),
+		targetOperand(int32(ins.Offset())),
+	}
+	return d, nil
+}
+
+func decodeCall(op JumpOpcode, ins Instruction) (Decoded, error) {
+	if op.Source() != BPF_K {
+		return Decoded{}, decodeErrorf(ins, "call does not support the BPF_X source")
+	}
+	if err := unused(ins, "call", fieldDst); err != nil {
+		return Decoded{}, err
+	}
+	// only the kernel function calls use offset, for the BTF of their module
+	if ins.Regs().SrcReg() != 0x2 {
+		if err := unused(ins, "call", fieldOffset); err != nil {
+			return Decoded{}, err
+		}
+	}
+	d := Decoded{Kind: KindCall, Operation: "call"}
+	// the kind of call is stored in the src_reg field
+	switch ins.Regs().SrcReg() {
+	case 0x0:
+		d.Operands = []Operand{{Kind: OperandHelper, Imm: int64(ins.Imm())}}
+	case 0x1:
+		// program-local calls store the relative target in imm
+		d.Operands = []Operand{targetOperand(int32(ins.Imm()))}
+	case 0x2:
+		// kernel function calls store a BTF ID, not a helper ID, and the index
+		// of the module BTF in offset, 0 for vmlinux
+		d.Operands = []Operand{{Kind: OperandKfunc, Imm: int64(ins.Imm()), Offset: int32(ins.Offset())}}
+	default:
+		return Decoded{}, decodeErrorf(ins, "unknown call kind 0x%x", uint8(ins.Regs().SrcReg()))
+	}
+	return d, nil
+}
+
+func decodeLoadAndStore(op LoadAndStoreOpcode, ins Instruction) (Decoded, error) {
+	class := ins.Opcode().Class()
+	dst, src := ins.Regs().DstReg(), ins.Regs().SrcReg()
+	switch op.Mode() {
+	case BPF_IMM:
+		return decodeImm64(op, ins)
+	case BPF_ABS, BPF_IND:
+		return decodePacket(op, ins)
+	case BPF_ATOMIC:
+		return decodeAtomic(op, ins)
+	case BPF_MEMSX:
+		if class != BPF_LDX || op.Size() == BPF_DW {
+			return Decoded{}, decodeErrorf(ins, "sign-extension load does not support %s | %s", class, op.Size())
+		}
+		if err := unused(ins, "ldxs", fieldImm); err != nil {
+			return Decoded{}, err
+		}
+		mem := memoryOperand(src, ins.Offset(), op.Size())
+		mem.Signed = true
+		return Decoded{Kind: KindLoad, Operation: "ldxs", Operands: []Operand{registerOperand(dst, false), mem}}, nil
+	case BPF_MEM:
+	default:
+		return Decoded{}, decodeErrorf(ins, "unknown load and store mode 0x%02x", uint8(op.Mode()))
+	}
+
+	switch class {
+	case BPF_LDX:
+		if err := unused(ins, "ldx", fieldImm); err != nil {
+			return Decoded{}, err
+		}
+		return Decoded{Kind: KindLoad, Operation: "ldx", Operands: []Operand{registerOperand(dst, false), memoryOperand(src, ins.Offset(), op.Size())}}, nil
+	case BPF_ST:
+		if err := unused(ins, "st", fieldSrc); err != nil {
+			return Decoded{}, err
+		}
+		return Decoded{Kind: KindStore, Operation: "st", Operands: []Operand{memoryOperand(dst, ins.Offset(), op.Size()), immediateOperand(int64(ins.Imm()))}}, nil
+	case BPF_STX:
+		if err := unused(ins, "stx", fieldImm); err != nil {
+			return Decoded{}, err
+		}
+		return Decoded{Kind: KindStore, Operation: "stx", Operands: []Operand{memoryOperand(dst, ins.Offset(), op.Size()), registerOperand(src, false)}}, nil
+	default:
+		return Decoded{}, decodeErrorf(ins, "memory access does not support the %s class", class)
+	}
+}
+
+func decodeImm64(op LoadAndStoreOpcode, ins Instruction) (Decoded, error) {
+	if ins.Opcode().Class() != BPF_LD || op.Size() != BPF_DW {
+		return Decoded{}, decodeErrorf(ins, "64-bit immediate load must be BPF_LD | BPF_DW, got %s | %s", ins.Opcode().Class(), op.Size())
+	}
+	if !ins.Extended64 {
+		return Decoded{}, decodeErrorf(ins, "64-bit immediate load is missing its pseudo instruction")
+	}
+	if err := unused(ins, "lddw", fieldOffset); err != nil {
+		return Decoded{}, err
+	}
+	// the opcode, registers and offset of the pseudo instruction are in its
+	// first 4 bytes, whatever the byte order
+	if ins.Pseudo>>32 != 0 {
+		return Decoded{}, decodeErrorf(ins, "64-bit immediate load pseudo instruction only holds an imm, got 0x%016x", ins.Pseudo)
+	}
+
+	imm := Operand{Kind: OperandImmediate, Pseudo: ins.ImmSrc()}
+	switch ins.ImmSrc() {
+	case BPF_IMM0:
+		imm.Imm = int64(ins.Imm64())
+	case BPF_IMM1, BPF_IMM3, BPF_IMM4, BPF_IMM5:
+		if ins.NextImm() != 0 {
+			return Decoded{}, decodeErrorf(ins, "64-bit immediate source 0x%x does not use the next imm, got %d", uint8(ins.ImmSrc()), ins.NextImm())
+		}
+		imm.Imm = int64(ins.Imm())
+	case BPF_IMM2, BPF_IMM6:
+		imm.Imm, imm.Offset = int64(ins.Imm()), int32(ins.NextImm())
+	default:
+		return Decoded{}, decodeErrorf(ins, "unknown 64-bit immediate source 0x%x", uint8(ins.ImmSrc()))
+	}
+	return Decoded{Kind: KindLoadImm64, Operation: "lddw", Operands: []Operand{registerOperand(ins.Regs().DstReg(), false), imm}}, nil
+}
+
+// decodePacket decodes the legacy packet access instructions inherited from
+// cBPF, they implicitly load from the skb in r6 into r0
+func decodePacket(op LoadAndStoreOpcode, ins Instruction) (Decoded, error) {
+	if ins.Opcode().Class() != BPF_LD {
+		return Decoded{}, decodeErrorf(ins, "packet access does not support the %s class", ins.Opcode().Class())
+	}
+	if op.Size() == BPF_DW {
+		return Decoded{}, decodeErrorf(ins, "packet access on size %s is not supported", op.Size())
+	}
+
+	packet := Operand{Kind: OperandPacket, Imm: int64(ins.Imm()), Size: op.Size()}
+	d := Decoded{Kind: KindLoadPacket, Operation: "ldabs"}
+	if op.Mode() == BPF_IND {
+		d.Operation = "ldind"
+		packet.Reg, packet.Indirect = ins.Regs().SrcReg(), true
+	}
+	// src_reg is only used by the indirect accesses
+	fields := []field{fieldDst, fieldOffset}
+	if !packet.Indirect {
+		fields = append(fields, fieldSrc)
+	}
+	if err := unused(ins, d.Operation, fields...); err != nil {
+		return Decoded{}, err
+	}
+	d.Operands = []Operand{packet}
+	return d, nil
+}
+
+func decodeAtomic(op LoadAndStoreOpcode, ins Instruction) (Decoded, error) {
+	if ins.Opcode().Class() != BPF_STX {
+		return Decoded{}, decodeErrorf(ins, "atomic operation does not support the %s class", ins.Opcode().Class())
+	}
+	d := Decoded{Kind: KindAtomic}
+	switch op.Size() {
+	case BPF_W:
+		d.Width = 32
+	case BPF_DW:
+		d.Width = 64
+	default:
+		return Decoded{}, decodeErrorf(ins, "atomic operation on size %s is not supported", op.Size())
+	}
+
+	switch ins.AtomicOperationImm() {
+	case BPF_XCHG:
+		d.Operation = "xchg"
+	case BPF_CMPXCHG:
+		d.Operation = "cmpxchg"
+	default:
+		name, _, ok := atomicArithmetic(ins)
+		if !ok {
+			return Decoded{}, decodeErrorf(ins, "atomic operation does not support the 0x%02x operation", ins.Imm())
+		}
+		d.Operation = name
+		if atomicFetch(ins) {
+			d.Operation = "fetch_" + name
+		}
+	}
+	d.Operands = []Operand{
+		memoryOperand(ins.Regs().DstReg(), ins.Offset(), op.Size()),
+		registerOperand(ins.Regs().SrcReg(), d.Width == 32),
+	}
+	return d, nil
+}
+
+// atomicArithmetic returns the name and the operator of the atomic operations
+// that are not exchanges, the BPF_FETCH modifier aside
+func atomicArithmetic(ins Instruction) (name, operator string, ok bool) {
+	switch ins.AtomicOperationImm() &^ AtomicOperation(BPF_FETCH) {
+	case AtomicOperation(BPF_ADD):
+		return "add", "+", true
+	case AtomicOperation(BPF_OR):
+		return "or", "|", true
+	case AtomicOperation(BPF_AND):
+		return "and", "&", true
+	case AtomicOperation(BPF_XOR):
+		return "xor", "^", true
+	default:
+		return "", "", false
+	}
+}
+
+// atomicFetch reports whether the atomic operation loads the old value back
+// into src
+func atomicFetch(ins Instruction) bool {
+	return ins.AtomicOperationImm()&AtomicOperation(BPF_FETCH) != 0
+}
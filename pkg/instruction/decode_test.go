@@ -0,0 +1,225 @@
+package instruction
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		ins      Instruction
+		kind     Kind
+		mnemonic string
+		operands []Operand
+		reads    []Register
+		writes   []Register
+	}{
+		{
+			name:     "add imm",
+			ins:      NewInstruction(0x07020000fcffffff),
+			kind:     KindArithmetic,
+			mnemonic: "add64",
+			operands: []Operand{registerOperand(BPF_R2, false), immediateOperand(-4)},
+			reads:    []Register{BPF_R2},
+			writes:   []Register{BPF_R2},
+		},
+		{
+			name:     "32-bit xor",
+			ins:      NewInstruction(0xac21000000000000),
+			kind:     KindArithmetic,
+			mnemonic: "xor32",
+			operands: []Operand{registerOperand(BPF_R1, true), registerOperand(BPF_R2, true)},
+			reads:    []Register{BPF_R1, BPF_R2},
+			writes:   []Register{BPF_R1},
+		},
+		{
+			name:     "move",
+			ins:      NewInstruction(0xbfa2000000000000),
+			kind:     KindMove,
+			mnemonic: "mov64",
+			operands: []Operand{registerOperand(BPF_R2, false), registerOperand(BPF_R10, false)},
+			reads:    []Register{BPF_R10},
+			writes:   []Register{BPF_R2},
+		},
+		{
+			name:     "sign-extension move",
+			ins:      NewInstruction(0xbf21100000000000),
+			kind:     KindMove,
+			mnemonic: "movsx16_64",
+			operands: []Operand{registerOperand(BPF_R1, false), {Kind: OperandRegister, Reg: BPF_R2, Size: BPF_H, Signed: true}},
+			reads:    []Register{BPF_R2},
+			writes:   []Register{BPF_R1},
+		},
+		{
+			name:     "load",
+			ins:      NewInstruction(0x6112040000000000),
+			kind:     KindLoad,
+			mnemonic: "ldxw",
+			operands: []Operand{registerOperand(BPF_R2, false), memoryOperand(BPF_R1, 4, BPF_W)},
+			reads:    []Register{BPF_R1},
+			writes:   []Register{BPF_R2},
+		},
+		{
+			name:     "store",
+			ins:      NewInstruction(0x631afcff00000000),
+			kind:     KindStore,
+			mnemonic: "stxw",
+			operands: []Operand{memoryOperand(BPF_R10, -4, BPF_W), registerOperand(BPF_R1, false)},
+			reads:    []Register{BPF_R1, BPF_R10},
+		},
+		{
+			name:     "atomic compare and exchange",
+			ins:      NewInstruction(0xdb1af8fff1000000),
+			kind:     KindAtomic,
+			mnemonic: "stxcmpxchgdw",
+			operands: []Operand{memoryOperand(BPF_R10, -8, BPF_DW), registerOperand(BPF_R1, false)},
+			reads:    []Register{BPF_R0, BPF_R1, BPF_R10},
+			writes:   []Register{BPF_R0},
+		},
+		{
+			name:     "map load",
+			ins:      Instruction{Basic: 0x1811000003000000, Extended64: true},
+			kind:     KindLoadImm64,
+			mnemonic: "lddw",
+			operands: []Operand{registerOperand(BPF_R1, false), {Kind: OperandImmediate, Imm: 3, Pseudo: BPF_IMM1}},
+			writes:   []Register{BPF_R1},
+		},
+		{
+			name:     "indirect packet load",
+			ins:      NewInstruction(0x501000000e000000),
+			kind:     KindLoadPacket,
+			mnemonic: "ldindb",
+			operands: []Operand{{Kind: OperandPacket, Reg: BPF_R1, Imm: 14, Size: BPF_B, Indirect: true}},
+			reads:    []Register{BPF_R1, BPF_R6},
+			writes:   []Register{BPF_R0, BPF_R1, BPF_R2, BPF_R3, BPF_R4, BPF_R5},
+		},
+		{
+			name:     "32-bit branch",
+			ins:      NewInstruction(0x1601ffff2a000000),
+			kind:     KindBranch,
+			mnemonic: "jeq32",
+			operands: []Operand{registerOperand(BPF_R1, true), immediateOperand(42), targetOperand(-1)},
+			reads:    []Register{BPF_R1},
+		},
+		{
+			name:     "long jump",
+			ins:      NewInstruction(0x0600000000000100),
+			kind:     KindJump,
+			mnemonic: "gotol",
+			operands: []Operand{targetOperand(65536)},
+		},
+		{
+			name:     "helper call",
+			ins:      NewInstruction(0x850000000e000000),
+			kind:     KindCall,
+			mnemonic: "call",
+			operands: []Operand{{Kind: OperandHelper, Imm: 14}},
+			reads:    []Register{BPF_R1, BPF_R2, BPF_R3, BPF_R4, BPF_R5},
+			writes:   []Register{BPF_R0, BPF_R1, BPF_R2, BPF_R3, BPF_R4, BPF_R5},
+		},
+		{
+			name:     "kernel function call",
+			ins:      NewInstruction(0x8520000039300000),
+			kind:     KindCall,
+			mnemonic: "call",
+			operands: []Operand{{Kind: OperandKfunc, Imm: 12345}},
+			reads:    []Register{BPF_R1, BPF_R2, BPF_R3, BPF_R4, BPF_R5},
+			writes:   []Register{BPF_R0, BPF_R1, BPF_R2, BPF_R3, BPF_R4, BPF_R5},
+		},
+		{
+			name:     "exit",
+			ins:      NewInstruction(0x9500000000000000),
+			kind:     KindExit,
+			mnemonic: "exit",
+			reads:    []Register{BPF_R0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := tt.ins.Decode()
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if d.Kind != tt.kind {
+				t.Errorf("Kind = %s, want %s", d.Kind, tt.kind)
+			}
+			if got := d.Mnemonic(); got != tt.mnemonic {
+				t.Errorf("Mnemonic() = %q, want %q", got, tt.mnemonic)
+			}
+			if !reflect.DeepEqual(d.Operands, tt.operands) {
+				t.Errorf("Operands = %+v, want %+v", d.Operands, tt.operands)
+			}
+			if got := d.Reads(); len(got) != len(tt.reads) || len(got) > 0 && !reflect.DeepEqual(got, tt.reads) {
+				t.Errorf("Reads() = %v, want %v", got, tt.reads)
+			}
+			if got := d.Writes(); len(got) != len(tt.writes) || len(got) > 0 && !reflect.DeepEqual(got, tt.writes) {
+				t.Errorf("Writes() = %v, want %v", got, tt.writes)
+			}
+		})
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	_, err := NewInstruction(0xe701000000000000).Decode()
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("Decode() error = %v, want a DecodeError", err)
+	}
+}
+
+func TestDecodeReservedFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		ins    Instruction
+		reason string
+	}{
+		{
+			name:   "exit with imm",
+			ins:    NewInstruction(0x9500000001000000),
+			reason: "exit does not use the imm field, got 1",
+		},
+		{
+			name:   "BPF_X with imm",
+			ins:    NewInstruction(0x0f21000001000000),
+			reason: "add does not use the imm field, got 1",
+		},
+		{
+			name:   "register out of range",
+			ins:    NewInstruction(0xb70f000000000000),
+			reason: "register 15 is out of the r0-r10 range",
+		},
+		{
+			name: "64-bit immediate load with a pseudo opcode",
+			ins: func() Instruction {
+				ins := NewInstruction(0x1801000000000000)
+				ins.AddPseudoInstruction(0x1800000000000000)
+				return ins
+			}(),
+			reason: "64-bit immediate load pseudo instruction only holds an imm, got 0x1800000000000000",
+		},
+		{
+			name: "map load with next imm",
+			ins: func() Instruction {
+				ins := NewInstruction(0x1811000001000000)
+				ins.AddPseudoInstruction(0x0000000001000000)
+				return ins
+			}(),
+			reason: "64-bit immediate source 0x1 does not use the next imm, got 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.ins.Decode()
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("Decode() error = %v, want a DecodeError", err)
+			}
+			if decodeErr.Reason != tt.reason {
+				t.Errorf("Decode() reason = %q, want %q", decodeErr.Reason, tt.reason)
+			}
+		})
+	}
+}
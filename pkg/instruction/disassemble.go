@@ -3,162 +3,95 @@ package instruction
 import (
 	"fmt"
 	"strings"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
 )
 
 const (
 	buggyCase = "this case should be impossible, this is a bug!"
 )
 
-func helperAssignment(operator string, op ArithmeticOpcode, ins Instruction) string {
-	switch op.Source() {
-	case BPF_K:
-		return fmt.Sprintf("%s %s= %d", ins.Regs().DstReg(), operator, ins.Imm())
-	case BPF_X:
-		return fmt.Sprintf("%s %s= %s", ins.Regs().DstReg(), operator, ins.Regs().SrcReg())
-	default:
-		panic(buggyCase)
-	}
-}
-
-func helperShiftmask(operator string, op ArithmeticOpcode, ins Instruction) string {
-	var mask uint8
-	switch ins.Opcode().Class() {
-	case BPF_ALU:
-		mask = 0x1F
-	case BPF_ALU64:
-		mask = 0x3F
-	default:
-		panic(buggyCase)
-	}
+// llvmSyntax is the C-like syntax of llvm-objdump, like r1 = *(u32 *)(r2 + 4)
+type llvmSyntax struct{}
 
-	switch op.Source() {
-	case BPF_K:
-		return fmt.Sprintf("%s %s= %d", ins.Regs().DstReg(), operator, ins.Imm()&Imm(mask))
-	case BPF_X:
-		return fmt.Sprintf("%s %s= (%s & %d)", ins.Regs().DstReg(), operator, ins.Regs().SrcReg(), mask)
-	default:
-		panic(buggyCase)
-	}
+func (llvmSyntax) Name() string {
+	return "llvm"
 }
 
-func disassembleArithmetic(op ArithmeticOpcode, ins Instruction) string {
-	switch op.Code() {
-	case BPF_ADD:
-		return helperAssignment("+", op, ins)
-	case BPF_SUB:
-		return helperAssignment("-", op, ins)
-	case BPF_MUL:
-		return helperAssignment("*", op, ins)
-	case BPF_DIV:
-		if ins.Regs().SrcReg() == 0 {
-			return fmt.Sprintf("%s = 0", ins.Regs().DstReg())
+func (llvmSyntax) Format(d Decoded, opts DisassembleOptions) string {
+	switch d.Kind {
+	case KindArithmetic:
+		return disassembleArithmetic(d)
+	case KindMove:
+		dst, src := d.Operands[0], d.Operands[1]
+		if src.Signed {
+			return fmt.Sprintf("%s = (s%d)%s", llvmOperand(dst), sizeBits(src.Size), llvmOperand(src))
+		}
+		return fmt.Sprintf("%s = %s", llvmOperand(dst), llvmOperand(src))
+	case KindByteSwap:
+		return disassembleByteSwap(d)
+	case KindLoad:
+		return fmt.Sprintf("%s = %s", llvmOperand(d.Operands[0]), llvmLoad(d.Operands[1]))
+	case KindStore:
+		return fmt.Sprintf("%s = %s", llvmOperand(d.Operands[0]), llvmOperand(d.Operands[1]))
+	case KindAtomic:
+		return disassembleAtomic(d)
+	case KindLoadImm64:
+		dst, imm := d.Operands[0], d.Operands[1]
+		if imm.Pseudo == BPF_IMM0 {
+			return fmt.Sprintf("%s = %d ll", dst.Reg, imm.Imm)
 		}
-		return helperAssignment("/", op, ins)
-	case BPF_OR:
-		return helperAssignment("|", op, ins)
-	case BPF_AND:
-		return helperAssignment("&", op, ins)
-	case BPF_LSH:
-		return helperShiftmask("<<", op, ins)
-	case BPF_RSH:
-		return helperShiftmask(">>", op, ins)
-	case BPF_NEG:
-		return fmt.Sprintf("%s = ~%s", ins.Regs().DstReg(), ins.Regs().SrcReg())
-	case BPF_MOD:
-		if ins.Regs().SrcReg() == 0 {
-			return fmt.Sprintf("%s = %s", ins.Regs().DstReg(), ins.Regs().DstReg())
+		return fmt.Sprintf("%s = %s", dst.Reg, pseudoImm(imm))
+	case KindLoadPacket:
+		return fmt.Sprintf("%s = %s", BPF_R0, llvmOperand(d.Operands[0]))
+	case KindJump:
+		if d.Width == 32 {
+			return fmt.Sprintf("gotol %+d", d.Operands[0].Offset)
 		}
-		return helperAssignment("%", op, ins)
-	case BPF_XOR:
-		return helperAssignment("^", op, ins)
-	case BPF_MOV:
-		return helperAssignment("", op, ins)
-	case BPF_ARSH:
-		return helperShiftmask("s>>", op, ins)
-	case BPF_END:
-		return "byte swap! TODO"
+		return fmt.Sprintf("goto %+d", d.Operands[0].Offset)
+	case KindBranch:
+		return fmt.Sprintf("if %s %s %s goto %+d", llvmOperand(d.Operands[0]), jumpOperators[d.Operation], llvmOperand(d.Operands[1]), d.Operands[2].Offset)
+	case KindCall:
+		return disassembleCall(d.Operands[0], opts)
+	case KindExit:
+		return "exit"
 	default:
-		panic(buggyCase)
+		return buggyCase
 	}
 }
 
-func helperJumpConditional(operator string, op JumpOpcode, ins Instruction) string {
-	switch op.Source() {
-	case BPF_K:
-		return fmt.Sprintf("if %s %s %d goto +%d", ins.Regs().DstReg(), operator, ins.Imm(), ins.Offset())
-	case BPF_X:
-		return fmt.Sprintf("if %s %s %s goto +%d", ins.Regs().DstReg(), operator, ins.Regs().SrcReg(), ins.Offset())
-	default:
-		panic(buggyCase)
+func (llvmSyntax) FormatSymbol(d Decoded, symbol string, isMap bool) string {
+	dst := d.Operands[0].Reg
+	if isMap {
+		return fmt.Sprintf("%s = map_by_fd(%s) ll", dst, symbol)
 	}
+	if offset := d.Operands[1].Imm; offset != 0 {
+		return fmt.Sprintf("%s = %s + %d ll", dst, symbol, offset)
+	}
+	return fmt.Sprintf("%s = %s ll", dst, symbol)
 }
 
-func disassembleJump(op JumpOpcode, ins Instruction) string {
-	switch op.Code() {
-	case BPF_JA:
-		return fmt.Sprintf("goto +%d", ins.Offset())
-	case BPF_JEQ:
-		return helperJumpConditional("==", op, ins)
-	case BPF_JGT:
-		return helperJumpConditional(">", op, ins)
-	case BPF_JGE:
-		return helperJumpConditional(">=", op, ins)
-	case BPF_JSET:
-		return helperJumpConditional("&", op, ins)
-	case BPF_JNE:
-		return helperJumpConditional("!=", op, ins)
-	case BPF_JSGT:
-		return helperJumpConditional("s>", op, ins)
-	case BPF_JSGE:
-		return helperJumpConditional("s>=", op, ins)
-	case BPF_CALL:
-		switch op.Source() {
-		case BPF_K, 0x2:
-			return fmt.Sprintf("call %d", ins.Imm())
-		case 0x1:
-			return fmt.Sprintf("call +%d", ins.Offset())
-		default:
-			panic(buggyCase)
-		}
-	case BPF_EXIT:
-		return "exit"
-	case BPF_JLT:
-		return helperJumpConditional("<", op, ins)
-	case BPF_JLE:
-		return helperJumpConditional("<=", op, ins)
-	case BPF_JSLT:
-		return helperJumpConditional("s<", op, ins)
-	case BPF_JSLE:
-		return helperJumpConditional("s<=", op, ins)
-	default:
-		panic(buggyCase)
-	}
+func (llvmSyntax) FormatCall(d Decoded, h helper.Helper, args []string) string {
+	return fmt.Sprintf("call %s#%d(%s)", h.Name, h.ID, strings.Join(args, ", "))
 }
 
-func disassembleImm(ins Instruction) string {
-	switch ins.ImmSrc() {
-	case BPF_IMM0:
-		return fmt.Sprintf("%s = %d ll", ins.Regs().DstReg(), ins.Imm64())
-	case BPF_IMM1:
-		return fmt.Sprintf("%s = map_by_fd(%d)", ins.Regs().DstReg(), ins.Imm())
-	case BPF_IMM2:
-		return fmt.Sprintf("%s = map_val(map_by_fd(%d)) + %d", ins.Regs().DstReg(), ins.Imm(), ins.NextImm())
-	case BPF_IMM3:
-		return fmt.Sprintf("%s = var_addr(%d)", ins.Regs().DstReg(), ins.Imm())
-	case BPF_IMM4:
-		return fmt.Sprintf("%s = code_addr(%d)", ins.Regs().DstReg(), ins.Imm())
-	case BPF_IMM5:
-		return fmt.Sprintf("%s = map_by_idx(%d)", ins.Regs().DstReg(), ins.Imm())
-	case BPF_IMM6:
-		return fmt.Sprintf("%s = map_val(map_by_idx(%d)) + %d", ins.Regs().DstReg(), ins.Imm(), ins.NextImm())
-	default:
-		panic(buggyCase)
+// registerName returns the name of the register operand, the 32-bit
+// subregisters are named w0 to w10
+func registerName(o Operand) string {
+	if !o.Sub {
+		return o.Reg.String()
 	}
+	// Decode rejects the registers out of range
+	name, err := o.Reg.SubRegister()
+	if err != nil {
+		return buggyCase
+	}
+	return name
 }
 
 // helperOffsetOperator helps to simplify the visual representation of
 // operations such as (a + -b) to (a - b).
-func helperOffsetOperator(off Offset) (offset Offset, operator string) {
+func helperOffsetOperator(off int64) (offset int64, operator string) {
 	if off < 0 {
 		operator = "-"
 		offset = -off
@@ -169,88 +102,203 @@ func helperOffsetOperator(off Offset) (offset Offset, operator string) {
 	return offset, operator
 }
 
-func disassembleMem(op LoadAndStoreOpcode, ins Instruction) string {
-	offset, operator := helperOffsetOperator(ins.Offset())
-
-	// TODO: understand the difference between the size and unsigned size
-	// "Where size is one of: BPF_B, BPF_H, BPF_W, or BPF_DW and 'unsigned size' is one of u8, u16, u32 or u64."
-	switch ins.Opcode().Class() {
-	case BPF_STX:
-		return fmt.Sprintf("*(%s *)(%s %s %d) = %s", op.Size(), ins.Regs().DstReg(), operator, offset, ins.Regs().SrcReg())
-	case BPF_ST:
-		return fmt.Sprintf("*(%s *)(%s %s %d) = %d", op.Size(), ins.Regs().DstReg(), operator, offset, ins.Imm())
-	case BPF_LDX:
-		return fmt.Sprintf("%s = *(%s *)(%s + %d)", ins.Regs().DstReg(), op.Size(), ins.Regs().SrcReg(), ins.Offset())
+// llvmOperand renders the registers, the immediates and the memory and packet
+// references
+func llvmOperand(o Operand) string {
+	switch o.Kind {
+	case OperandRegister:
+		return registerName(o)
+	case OperandMemory:
+		offset, operator := helperOffsetOperator(int64(o.Offset))
+		return fmt.Sprintf("*(%s *)(%s %s %d)", o.Size, o.Reg, operator, offset)
+	case OperandPacket:
+		if !o.Indirect {
+			return fmt.Sprintf("*(%s *)skb[%d]", o.Size, o.Imm)
+		}
+		if o.Imm == 0 {
+			return fmt.Sprintf("*(%s *)skb[%s]", o.Size, o.Reg)
+		}
+		imm, operator := helperOffsetOperator(o.Imm)
+		return fmt.Sprintf("*(%s *)skb[%s %s %d]", o.Size, o.Reg, operator, imm)
 	default:
-		panic(buggyCase)
+		return fmt.Sprint(o.Imm)
 	}
 }
 
-func disassembleAtomic(op LoadAndStoreOpcode, ins Instruction) string {
-	offset, offsetOperator := helperOffsetOperator(ins.Offset())
+// llvmLoad renders the memory reference of a load, which llvm-objdump always
+// writes with a plus sign
+func llvmLoad(mem Operand) string {
+	size := mem.Size.String()
+	if mem.Signed {
+		size = "s" + strings.TrimPrefix(size, "u")
+	}
+	return fmt.Sprintf("*(%s *)(%s + %d)", size, mem.Reg, mem.Offset)
+}
+
+// arithmeticOperators are the operators of the arithmetic operations
+var arithmeticOperators = map[string]string{
+	"add":  "+",
+	"sub":  "-",
+	"mul":  "*",
+	"div":  "/",
+	"sdiv": "s/",
+	"or":   "|",
+	"and":  "&",
+	"lsh":  "<<",
+	"rsh":  ">>",
+	"mod":  "%",
+	"smod": "s%",
+	"xor":  "^",
+	"arsh": "s>>",
+}
 
-	switch op.Size() {
-	case BPF_B, BPF_H:
-		panic(fmt.Errorf("atomic operation on size %q is not supported", op.Size()))
+// shiftMask returns the mask applied to the shift amounts, that wrap at the
+// width of the operation
+func shiftMask(d Decoded) int64 {
+	if d.Width == 32 {
+		return 0x1F
 	}
+	return 0x3F
+}
 
-	var operator string
-	switch ins.AtomicOperationImm() {
-	case AtomicOperation(BPF_ADD):
-		operator = "+"
-	case AtomicOperation(BPF_OR):
-		operator = "|"
-	case AtomicOperation(BPF_AND):
-		operator = "&"
-	case AtomicOperation(BPF_XOR):
-		operator = "^"
-	case BPF_XCHG:
-		panic("implement me")
-	case BPF_CMPXCHG:
-		panic("implement me")
+func disassembleArithmetic(d Decoded) string {
+	dst := d.Operands[0]
+	switch d.Operation {
+	case "neg":
+		return fmt.Sprintf("%s = -%s", registerName(dst), registerName(dst))
+	case "lsh", "rsh", "arsh":
+		mask := shiftMask(d)
+		if src := d.Operands[1]; src.Kind == OperandRegister {
+			return fmt.Sprintf("%s %s= (%s & %d)", registerName(dst), arithmeticOperators[d.Operation], registerName(src), mask)
+		}
+		return fmt.Sprintf("%s %s= %d", registerName(dst), arithmeticOperators[d.Operation], d.Operands[1].Imm&mask)
 	default:
-		panic(fmt.Errorf("atomic operation does not support the %q operator", OpcodeArithmetic(ins.Imm())))
+		return fmt.Sprintf("%s %s= %s", registerName(dst), arithmeticOperators[d.Operation], llvmOperand(d.Operands[1]))
+	}
+}
+
+func disassembleByteSwap(d Decoded) string {
+	dst := d.Operands[0].Reg
+	return fmt.Sprintf("%s = %s%d %s", dst, d.Operation, d.Width, dst)
+}
+
+// jumpOperators are the comparisons of the conditional jumps
+var jumpOperators = map[string]string{
+	"jeq":  "==",
+	"jgt":  ">",
+	"jge":  ">=",
+	"jset": "&",
+	"jne":  "!=",
+	"jsgt": "s>",
+	"jsge": "s>=",
+	"jlt":  "<",
+	"jle":  "<=",
+	"jslt": "s<",
+	"jsle": "s<=",
+}
+
+// helperName returns the name of the helper called, when it is known
+func helperName(call Operand, opts DisassembleOptions) (string, bool) {
+	if call.Kind != OperandHelper || opts.Helpers == nil {
+		return "", false
+	}
+	h, ok := opts.Helpers.Lookup(int32(call.Imm))
+	return h.Name, ok
+}
+
+// kfuncCall renders a kernel function call by its BTF ID, along with the index
+// of its module BTF when it is not in vmlinux, like call kfunc#1234 btf#1
+func kfuncCall(call Operand) string {
+	if call.Offset != 0 {
+		return fmt.Sprintf("call kfunc#%d btf#%d", call.Imm, call.Offset)
 	}
+	return fmt.Sprintf("call kfunc#%d", call.Imm)
+}
 
-	switch ins.Opcode().Class() {
-	case BPF_STX:
-		if ins.AtomicOperationImm()&AtomicOperation(BPF_FETCH) == 0x1 {
-			panic("implement me")
+func disassembleCall(call Operand, opts DisassembleOptions) string {
+	switch call.Kind {
+	case OperandTarget:
+		return fmt.Sprintf("call %+d", call.Offset)
+	case OperandHelper:
+		if name, ok := helperName(call, opts); ok {
+			return fmt.Sprintf("call %s#%d", name, call.Imm)
 		}
-		return fmt.Sprintf("*(%s *)(%s %s %d) %s= %s", op.Size(), ins.Regs().DstReg(), offsetOperator, offset, operator, ins.Regs().SrcReg())
+		return fmt.Sprintf("call %d", call.Imm)
 	default:
-		panic(fmt.Errorf("atomic operation does not support the %q class", ins.Opcode().Class()))
+		return kfuncCall(call)
 	}
 }
 
-func disassembleLoadAndStore(op LoadAndStoreOpcode, ins Instruction) string {
-	switch op.Mode() {
-	case BPF_IMM:
-		return disassembleImm(ins)
-	case BPF_ABS:
-		return "legacy BPF packet access (absolute)"
-	case BPF_IND:
-		return "legacy BPF packet access (indirect)"
-	case BPF_MEM:
-		return disassembleMem(op, ins)
-	case BPF_MEMSX:
-		return fmt.Sprintf("%s = *(s%s *)(%s + %d)", ins.Regs().DstReg(), strings.TrimPrefix(op.Size().String(), "u"), ins.Regs().SrcReg(), ins.Offset())
-	case BPF_ATOMIC:
-		return disassembleAtomic(op, ins)
+// pseudoImm returns the value loaded by a 64-bit immediate load whose imm is
+// not a plain integer
+func pseudoImm(imm Operand) string {
+	switch imm.Pseudo {
+	case BPF_IMM1:
+		return fmt.Sprintf("map_by_fd(%d)", imm.Imm)
+	case BPF_IMM2:
+		return fmt.Sprintf("map_val(map_by_fd(%d)) + %d", imm.Imm, imm.Offset)
+	case BPF_IMM3:
+		return fmt.Sprintf("var_addr(%d)", imm.Imm)
+	case BPF_IMM4:
+		return fmt.Sprintf("code_addr(%d)", imm.Imm)
+	case BPF_IMM5:
+		return fmt.Sprintf("map_by_idx(%d)", imm.Imm)
+	case BPF_IMM6:
+		return fmt.Sprintf("map_val(map_by_idx(%d)) + %d", imm.Imm, imm.Offset)
 	default:
-		panic(buggyCase)
+		return fmt.Sprint(imm.Imm)
+	}
+}
+
+func disassembleAtomic(d Decoded) string {
+	mem, src := d.Operands[0], d.Operands[1]
+	// suffix of the exchange operations, following the llvm naming, the
+	// values are in 32-bit subregisters for BPF_W
+	suffix, r0 := "_64", registerOperand(BPF_R0, src.Sub)
+	if d.Width == 32 {
+		suffix = "32_32"
+	}
+	offset, offsetOperator := helperOffsetOperator(int64(mem.Offset))
+
+	switch d.Operation {
+	case "xchg":
+		return fmt.Sprintf("%s = xchg%s(%s %s %d, %s)", registerName(src), suffix, mem.Reg, offsetOperator, offset, registerName(src))
+	case "cmpxchg":
+		return fmt.Sprintf("%s = cmpxchg%s(%s %s %d, %s, %s)", registerName(r0), suffix, mem.Reg, offsetOperator, offset, registerName(r0), registerName(src))
+	}
+
+	if name, fetch := strings.CutPrefix(d.Operation, "fetch_"); fetch {
+		return fmt.Sprintf("%s = atomic_fetch_%s((%s *)(%s %s %d), %s)", registerName(src), name, mem.Size, mem.Reg, offsetOperator, offset, registerName(src))
 	}
+	_, operator, _ := atomicArithmetic(d.Instruction)
+	return fmt.Sprintf("%s %s= %s", llvmOperand(mem), operator, registerName(src))
+}
+
+// DisassembleOptions tunes the rendering of the instructions
+type DisassembleOptions struct {
+	// Helpers names the helper calls, they are rendered with their ID only
+	// when it is nil or does not know the helper
+	Helpers *helper.Table
+	// Syntax renders the instructions, LLVMSyntax when it is nil
+	Syntax Syntax
+}
+
+// Disassemble returns the instruction in a C-like syntax similar to the one
+// of llvm-objdump, it returns an error for the encodings it cannot decode.
+// Helper calls are named after the default helper table.
+func (ins Instruction) Disassemble() (string, error) {
+	return ins.DisassembleWith(DisassembleOptions{Helpers: helper.Default()})
 }
 
-func (ins Instruction) Disassemble() string {
-	typedOpcode := ins.Opcode().ToTyped()
-	switch op := typedOpcode.(type) {
-	case ArithmeticOpcode:
-		return disassembleArithmetic(op, ins)
-	case JumpOpcode:
-		return disassembleJump(op, ins)
-	case LoadAndStoreOpcode:
-		return disassembleLoadAndStore(op, ins)
+// DisassembleWith is Disassemble with custom options
+func (ins Instruction) DisassembleWith(opts DisassembleOptions) (string, error) {
+	decoded, err := ins.Decode()
+	if err != nil {
+		return "", err
+	}
+	syntax := opts.Syntax
+	if syntax == nil {
+		syntax = LLVMSyntax
 	}
-	return ""
+	return syntax.Format(decoded, opts), nil
 }
@@ -1,6 +1,7 @@
 package instruction
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -70,11 +71,11 @@ func TestInstruction_Disassemble(t *testing.T) {
 			want: "*(u32 *)(r10 - 4) = r1",
 		},
 		{
-			name: "call 14",
+			name: "call bpf_get_current_pid_tgid#14",
 			fields: fields{
 				Basic: 0x850000000e000000,
 			},
-			want: "call 14",
+			want: "call bpf_get_current_pid_tgid#14",
 		},
 		{
 			name: "r6 = r0",
@@ -114,11 +115,57 @@ func TestInstruction_Disassemble(t *testing.T) {
 			want: "r1 = 0 ll",
 		},
 		{
-			name: "call 1",
+			name: "r2 = map_by_fd(3)",
+			fields: fields{
+				Basic:      0x1812000003000000,
+				Pseudo:     0x0000000000000000,
+				Extended64: true,
+			},
+			want: "r2 = map_by_fd(3)",
+		},
+		{
+			name: "r2 = map_val(map_by_idx(1)) + 8",
+			fields: fields{
+				Basic:      0x1862000001000000,
+				Pseudo:     0x0000000008000000,
+				Extended64: true,
+			},
+			want: "r2 = map_val(map_by_idx(1)) + 8",
+		},
+		{
+			name: "call +3",
+			fields: fields{
+				Basic: 0x8510000003000000,
+			},
+			want: "call +3",
+		},
+		{
+			name: "neg",
+			fields: fields{
+				Basic: 0x8701000000000000,
+			},
+			want: "r1 = -r1",
+		},
+		{
+			name: "32-bit neg",
+			fields: fields{
+				Basic: 0x8401000000000000,
+			},
+			want: "w1 = -w1",
+		},
+		{
+			name: "call -3",
+			fields: fields{
+				Basic: 0x85100000fdffffff,
+			},
+			want: "call -3",
+		},
+		{
+			name: "call bpf_map_lookup_elem#1",
 			fields: fields{
 				Basic: 0x8500000001000000,
 			},
-			want: "call 1",
+			want: "call bpf_map_lookup_elem#1",
 		},
 		{
 			name: "if r0 != 0 goto +9",
@@ -172,11 +219,81 @@ func TestInstruction_Disassemble(t *testing.T) {
 			want: "r4 = 0",
 		},
 		{
-			name: "call 2",
+			name: "r1 /= 3",
+			fields: fields{
+				Basic: 0x3701000003000000,
+			},
+			want: "r1 /= 3",
+		},
+		{
+			name: "r1 %= 5",
+			fields: fields{
+				Basic: 0x9701000005000000,
+			},
+			want: "r1 %= 5",
+		},
+		{
+			name: "r1 s/= r2",
+			fields: fields{
+				Basic: 0x3f21010000000000,
+			},
+			want: "r1 s/= r2",
+		},
+		{
+			name: "w1 s%= 3",
+			fields: fields{
+				Basic: 0x9401010003000000,
+			},
+			want: "w1 s%= 3",
+		},
+		{
+			name: "r1 = (s8)r2",
+			fields: fields{
+				Basic: 0xbf21080000000000,
+			},
+			want: "r1 = (s8)r2",
+		},
+		{
+			name: "w1 = (s16)w2",
+			fields: fields{
+				Basic: 0xbc21100000000000,
+			},
+			want: "w1 = (s16)w2",
+		},
+		{
+			name: "r1 = (s32)r2",
+			fields: fields{
+				Basic: 0xbf21200000000000,
+			},
+			want: "r1 = (s32)r2",
+		},
+		{
+			name: "call 999",
+			fields: fields{
+				Basic: 0x85000000e7030000,
+			},
+			want: "call 999",
+		},
+		{
+			name: "kernel function call",
+			fields: fields{
+				Basic: 0x8520000001000000,
+			},
+			want: "call kfunc#1",
+		},
+		{
+			name: "module kernel function call",
+			fields: fields{
+				Basic: 0x8520020001000000,
+			},
+			want: "call kfunc#1 btf#2",
+		},
+		{
+			name: "call bpf_map_update_elem#2",
 			fields: fields{
 				Basic: 0x8500000002000000,
 			},
-			want: "call 2",
+			want: "call bpf_map_update_elem#2",
 		},
 		{
 			name: "goto +1",
@@ -185,6 +302,167 @@ func TestInstruction_Disassemble(t *testing.T) {
 			},
 			want: "goto +1",
 		},
+		{
+			name: "r1 = be16 r1",
+			fields: fields{
+				Basic: 0xdc01000010000000,
+			},
+			want: "r1 = be16 r1",
+		},
+		{
+			name: "r1 = be64 r1",
+			fields: fields{
+				Basic: 0xdc01000040000000,
+			},
+			want: "r1 = be64 r1",
+		},
+		{
+			name: "r1 = le32 r1",
+			fields: fields{
+				Basic: 0xd401000020000000,
+			},
+			want: "r1 = le32 r1",
+		},
+		{
+			name: "r1 = bswap16 r1",
+			fields: fields{
+				Basic: 0xd701000010000000,
+			},
+			want: "r1 = bswap16 r1",
+		},
+		{
+			name: "r1 = bswap64 r1",
+			fields: fields{
+				Basic: 0xd701000040000000,
+			},
+			want: "r1 = bswap64 r1",
+		},
+		{
+			name: "*(u64 *)(r10 - 8) += r1",
+			fields: fields{
+				Basic: 0xdb1af8ff00000000,
+			},
+			want: "*(u64 *)(r10 - 8) += r1",
+		},
+		{
+			name: "r1 = atomic_fetch_add((u64 *)(r10 - 8), r1)",
+			fields: fields{
+				Basic: 0xdb1af8ff01000000,
+			},
+			want: "r1 = atomic_fetch_add((u64 *)(r10 - 8), r1)",
+		},
+		{
+			name: "w1 = atomic_fetch_or((u32 *)(r10 - 8), w1)",
+			fields: fields{
+				Basic: 0xc31af8ff41000000,
+			},
+			want: "w1 = atomic_fetch_or((u32 *)(r10 - 8), w1)",
+		},
+		{
+			name: "r1 = xchg_64(r10 - 8, r1)",
+			fields: fields{
+				Basic: 0xdb1af8ffe1000000,
+			},
+			want: "r1 = xchg_64(r10 - 8, r1)",
+		},
+		{
+			name: "r0 = cmpxchg_64(r10 - 8, r0, r1)",
+			fields: fields{
+				Basic: 0xdb1af8fff1000000,
+			},
+			want: "r0 = cmpxchg_64(r10 - 8, r0, r1)",
+		},
+		{
+			name: "w0 = cmpxchg32_32(r10 - 8, w0, w1)",
+			fields: fields{
+				Basic: 0xc31af8fff1000000,
+			},
+			want: "w0 = cmpxchg32_32(r10 - 8, w0, w1)",
+		},
+		{
+			name: "w1 += 3",
+			fields: fields{
+				Basic: 0x0401000003000000,
+			},
+			want: "w1 += 3",
+		},
+		{
+			name: "w1 = w2",
+			fields: fields{
+				Basic: 0xbc21000000000000,
+			},
+			want: "w1 = w2",
+		},
+		{
+			name: "w1 <<= (w2 & 31)",
+			fields: fields{
+				Basic: 0x6c21000000000000,
+			},
+			want: "w1 <<= (w2 & 31)",
+		},
+		{
+			name: "*(u32 *)(r10 - 8) += w1",
+			fields: fields{
+				Basic: 0xc31af8ff00000000,
+			},
+			want: "*(u32 *)(r10 - 8) += w1",
+		},
+		{
+			name: "if w1 > w2 goto +3",
+			fields: fields{
+				Basic: 0x2e21030000000000,
+			},
+			want: "if w1 > w2 goto +3",
+		},
+		{
+			name: "if w0 s< -1 goto +2",
+			fields: fields{
+				Basic: 0xc6000200ffffffff,
+			},
+			want: "if w0 s< -1 goto +2",
+		},
+		{
+			name: "if w3 & 0x10 goto +1",
+			fields: fields{
+				Basic: 0x4603010010000000,
+			},
+			want: "if w3 & 16 goto +1",
+		},
+		{
+			name: "gotol +65536",
+			fields: fields{
+				Basic: 0x0600000000000100,
+			},
+			want: "gotol +65536",
+		},
+		{
+			name: "r0 = *(u16 *)skb[12]",
+			fields: fields{
+				Basic: 0x280000000c000000,
+			},
+			want: "r0 = *(u16 *)skb[12]",
+		},
+		{
+			name: "r0 = *(u32 *)skb[-4096]",
+			fields: fields{
+				Basic: 0x2000000000f0ffff,
+			},
+			want: "r0 = *(u32 *)skb[-4096]",
+		},
+		{
+			name: "r0 = *(u8 *)skb[r1]",
+			fields: fields{
+				Basic: 0x5010000000000000,
+			},
+			want: "r0 = *(u8 *)skb[r1]",
+		},
+		{
+			name: "r0 = *(u8 *)skb[r1 + 14]",
+			fields: fields{
+				Basic: 0x501000000e000000,
+			},
+			want: "r0 = *(u8 *)skb[r1 + 14]",
+		},
 	}
 
 	for _, tt := range tests {
@@ -194,9 +472,204 @@ func TestInstruction_Disassemble(t *testing.T) {
 				Pseudo:     tt.fields.Pseudo,
 				Extended64: tt.fields.Extended64,
 			}
-			if got := ins.Disassemble(); got != tt.want {
+			got, err := ins.Disassemble()
+			if err != nil {
+				t.Fatalf("Instruction.Disassemble() error = %v", err)
+			}
+			if got != tt.want {
 				t.Errorf("Instruction.Disassemble() = %q, want %q", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestInstruction_DisassembleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		Basic uint64
+	}{
+		{
+			name:  "atomic on u8",
+			Basic: 0xd31af8ff00000000,
+		},
+		{
+			name:  "atomic on u16",
+			Basic: 0xcb1af8ff01000000,
+		},
+		{
+			name:  "packet access on u64",
+			Basic: 0x3800000000000000,
+		},
+		{
+			name:  "packet access from LDX",
+			Basic: 0x2900000000000000,
+		},
+		{
+			name:  "atomic unknown operation",
+			Basic: 0xdb1af8ff20000000,
+		},
+		{
+			name:  "division with an unknown offset",
+			Basic: 0x3f21020000000000,
+		},
+		{
+			name:  "sign-extension move from imm",
+			Basic: 0xb701080000000000,
+		},
+		{
+			name:  "sign-extension move from 32 bits in ALU",
+			Basic: 0xbc21200000000000,
+		},
+		{
+			name:  "call in JMP32",
+			Basic: 0x8600000001000000,
+		},
+		{
+			name:  "exit in JMP32",
+			Basic: 0x9600000000000000,
+		},
+		{
+			name:  "long jump with BPF_X",
+			Basic: 0x0e00000001000000,
+		},
+		{
+			name:  "unknown arithmetic operation",
+			Basic: 0xe701000000000000,
+		},
+		{
+			name:  "unknown jump operation",
+			Basic: 0xe500000000000000,
+		},
+		{
+			name:  "unknown load and store mode",
+			Basic: 0xa100000000000000,
+		},
+		{
+			name:  "memory access from LD",
+			Basic: 0x6000000000000000,
+		},
+		{
+			name:  "call from register",
+			Basic: 0x8d00000000000000,
+		},
+		{
+			name:  "unknown call kind",
+			Basic: 0x8530000001000000,
+		},
+		{
+			name:  "64-bit immediate load without pseudo instruction",
+			Basic: 0x1801000000000000,
+		},
+		{
+			name:  "byte swap with invalid width",
+			Basic: 0xdc01000008000000,
+		},
+		{
+			name:  "goto with imm",
+			Basic: 0x0500020000000100,
+		},
+		{
+			name:  "goto with BPF_X",
+			Basic: 0x0d00020000000000,
+		},
+		{
+			name:  "exit with imm",
+			Basic: 0x9500000001000000,
+		},
+		{
+			name:  "exit with registers",
+			Basic: 0x9512000000000000,
+		},
+		{
+			name:  "call with offset",
+			Basic: 0x8500010001000000,
+		},
+		{
+			name:  "BPF_X with imm",
+			Basic: 0x0f21000001000000,
+		},
+		{
+			name:  "BPF_K with src_reg",
+			Basic: 0x0721000001000000,
+		},
+		{
+			name:  "arithmetic with offset",
+			Basic: 0x0701010001000000,
+		},
+		{
+			name:  "neg with BPF_X",
+			Basic: 0x8f01000000000000,
+		},
+		{
+			name:  "neg with src_reg",
+			Basic: 0x8721000000000000,
+		},
+		{
+			name:  "byte swap with src_reg",
+			Basic: 0xdc21000010000000,
+		},
+		{
+			name:  "conditional jump with src_reg",
+			Basic: 0x1521010000000000,
+		},
+		{
+			name:  "store imm with src_reg",
+			Basic: 0x7a21000001000000,
+		},
+		{
+			name:  "load with imm",
+			Basic: 0x6121000001000000,
+		},
+		{
+			name:  "packet access with offset",
+			Basic: 0x3000010000000000,
+		},
+		{
+			name:  "64-bit shift out of range",
+			Basic: 0x6701000040000000,
+		},
+		{
+			name:  "negative shift",
+			Basic: 0xc7010000fcffffff,
+		},
+		{
+			name:  "dst register out of range",
+			Basic: 0xb70f000000000000,
+		},
+		{
+			name:  "src register out of range",
+			Basic: 0xbff1000000000000,
+		},
+		{
+			name:  "memory base register out of range",
+			Basic: 0x61b1000000000000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the encodings are checked the same way whatever the syntax
+			for _, syntax := range Syntaxes() {
+				_, err := NewInstruction(tt.Basic).DisassembleWith(DisassembleOptions{Syntax: syntax})
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("Instruction.DisassembleWith(%s) error = %v, want a DecodeError", syntax.Name(), err)
+				}
+				if decodeErr.Raw != tt.Basic || decodeErr.Index != -1 {
+					t.Errorf("DecodeError = %+v, want raw 0x%016x and index -1", decodeErr, tt.Basic)
+				}
+			}
+		})
+	}
+}
+
+func TestInstruction_DisassembleWith(t *testing.T) {
+	ins := NewInstruction(0x850000000e000000)
+	got, err := ins.DisassembleWith(DisassembleOptions{})
+	if err != nil {
+		t.Fatalf("DisassembleWith() error = %v", err)
+	}
+	if got != "call 14" {
+		t.Errorf("DisassembleWith() = %q, want %q", got, "call 14")
+	}
+}
@@ -0,0 +1,136 @@
+package instruction
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// NewArithmeticOpcode builds the opcode of an arithmetic instruction, class
+// should be BPF_ALU or BPF_ALU64
+func NewArithmeticOpcode(class OpcodeClass, code OpcodeArithmetic, source OpcodeSource) Opcode {
+	return Opcode(uint8(code) | uint8(source) | uint8(class))
+}
+
+// NewJumpOpcode builds the opcode of a jump instruction, class should be
+// BPF_JMP or BPF_JMP32
+func NewJumpOpcode(class OpcodeClass, code OpcodeJump, source OpcodeSource) Opcode {
+	return Opcode(uint8(code)<<4 | uint8(source) | uint8(class))
+}
+
+// NewLoadAndStoreOpcode builds the opcode of a load and store instruction,
+// class should be BPF_LD, BPF_LDX, BPF_ST or BPF_STX
+func NewLoadAndStoreOpcode(class OpcodeClass, size OpcodeSize, mode OpcodeMode) Opcode {
+	return Opcode(uint8(mode) | uint8(size) | uint8(class))
+}
+
+// NewRegs packs the destination and source register numbers
+func NewRegs(dst, src Register) Regs {
+	return Regs(uint8(src)<<4 | uint8(dst)&0x0F)
+}
+
+func (ins *Instruction) SetOpcode(o Opcode) {
+	ins.Basic = ins.Basic&^0xFF00_0000_0000_0000 | uint64(o)<<56
+}
+
+func (ins *Instruction) SetRegs(r Regs) {
+	if ins.BigEndian {
+		r = r<<4 | r>>4
+	}
+	ins.Basic = ins.Basic&^0x00FF_0000_0000_0000 | uint64(r)<<48
+}
+
+func (ins *Instruction) SetOffset(off Offset) {
+	if ins.BigEndian {
+		ins.Basic = ins.Basic&^0x0000_FFFF_0000_0000 | uint64(uint16(off))<<32
+		return
+	}
+	// it's little endian
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], uint16(off))
+	ins.Basic = ins.Basic&^0x0000_FFFF_0000_0000 | uint64(binary.BigEndian.Uint16(b[:]))<<32
+}
+
+// encodeImm returns word with its imm field replaced by imm
+func encodeImm(word uint64, imm Imm, bigEndian bool) uint64 {
+	if bigEndian {
+		return word&^0x0000_0000_FFFF_FFFF | uint64(uint32(imm))
+	}
+	// it's little endian
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(imm))
+	return word&^0x0000_0000_FFFF_FFFF | uint64(binary.BigEndian.Uint32(b[:]))
+}
+
+func (ins *Instruction) SetImm(imm Imm) {
+	ins.Basic = encodeImm(ins.Basic, imm, ins.BigEndian)
+}
+
+func (ins *Instruction) SetNextImm(imm Imm) {
+	ins.Pseudo = encodeImm(ins.Pseudo, imm, ins.BigEndian)
+	ins.Extended64 = true
+}
+
+// SetImm64 splits the 64-bit immediate between the imm of the instruction and
+// the imm of its pseudo instruction
+func (ins *Instruction) SetImm64(imm Imm64) {
+	ins.SetImm(Imm(imm))
+	ins.SetNextImm(Imm(imm >> 32))
+}
+
+// Encode builds an instruction from its fields, it's the inverse of the
+// Opcode, Regs, Offset and Imm accessors
+func Encode(opcode Opcode, regs Regs, offset Offset, imm Imm) Instruction {
+	ins := Instruction{}
+	ins.SetOpcode(opcode)
+	ins.SetRegs(regs)
+	ins.SetOffset(offset)
+	ins.SetImm(imm)
+	return ins
+}
+
+// EncodeImm64 builds a 64-bit immediate instruction along with its pseudo
+// instruction
+func EncodeImm64(opcode Opcode, regs Regs, imm Imm64) Instruction {
+	ins := Encode(opcode, regs, 0, 0)
+	ins.SetImm64(imm)
+	return ins
+}
+
+// MarshalBinary returns the instruction as it is laid out in a program, 8
+// bytes or 16 bytes for instructions that come with a pseudo instruction.
+func (ins Instruction) MarshalBinary() ([]byte, error) {
+	if !ins.Extended64 {
+		return binary.BigEndian.AppendUint64(nil, ins.Basic), nil
+	}
+	data := make([]byte, 0, 16)
+	data = binary.BigEndian.AppendUint64(data, ins.Basic)
+	return binary.BigEndian.AppendUint64(data, ins.Pseudo), nil
+}
+
+// UnmarshalBinary reads an instruction as it is laid out in a program, data
+// must be 16 bytes long for instructions that need a pseudo instruction and 8
+// bytes long otherwise. The instruction is read in the byte order of ins, set
+// BigEndian beforehand to read a big-endian instruction.
+func (ins *Instruction) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 && len(data) != 16 {
+		return fmt.Errorf("instruction must be 8 or 16 bytes long, got %d", len(data))
+	}
+
+	newInstruction := NewInstruction
+	if ins.BigEndian {
+		newInstruction = NewBigEndianInstruction
+	}
+	decoded := newInstruction(binary.BigEndian.Uint64(data[:8]))
+	switch {
+	case decoded.NeedPseudoInstruction() && len(data) == 8:
+		return fmt.Errorf("ins 0x%016x needs a pseudo instruction and it's not available", decoded.Basic)
+	case !decoded.NeedPseudoInstruction() && len(data) == 16:
+		return errors.New("only 64-bit immediate instructions are 16 bytes long")
+	case len(data) == 16:
+		decoded.AddPseudoInstruction(binary.BigEndian.Uint64(data[8:]))
+	}
+
+	*ins = decoded
+	return nil
+}
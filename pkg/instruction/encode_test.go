@@ -0,0 +1,156 @@
+package instruction
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		opcode Opcode
+		regs   Regs
+		offset Offset
+		imm    Imm
+		want   uint64
+	}{
+		{
+			name:   "example",
+			opcode: NewArithmeticOpcode(BPF_ALU64, BPF_ADD, BPF_K),
+			regs:   NewRegs(BPF_R1, BPF_R0),
+			imm:    0x11223344,
+			want:   exampleInstruction,
+		},
+		{
+			name:   "*(u32 *)(r10 - 4) = r1",
+			opcode: NewLoadAndStoreOpcode(BPF_STX, BPF_W, BPF_MEM),
+			regs:   NewRegs(BPF_R10, BPF_R1),
+			offset: -4,
+			want:   0x631afcff00000000,
+		},
+		{
+			name:   "r2 += -4",
+			opcode: NewArithmeticOpcode(BPF_ALU64, BPF_ADD, BPF_K),
+			regs:   NewRegs(BPF_R2, BPF_R0),
+			imm:    -4,
+			want:   0x07020000fcffffff,
+		},
+		{
+			name:   "if r0 != 0 goto +9",
+			opcode: NewJumpOpcode(BPF_JMP, BPF_JNE, BPF_K),
+			regs:   NewRegs(BPF_R0, BPF_R0),
+			offset: 9,
+			want:   0x5500090000000000,
+		},
+		{
+			name:   "if r2 != r1 goto +92",
+			opcode: NewJumpOpcode(BPF_JMP, BPF_JNE, BPF_X),
+			regs:   NewRegs(BPF_R2, BPF_R1),
+			offset: 92,
+			want:   0x5d125c0000000000,
+		},
+		{
+			name:   "call 14",
+			opcode: NewJumpOpcode(BPF_JMP, BPF_CALL, BPF_K),
+			imm:    14,
+			want:   0x850000000e000000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ins := Encode(tt.opcode, tt.regs, tt.offset, tt.imm)
+			if ins.Basic != tt.want {
+				t.Fatalf("Encode() = %016x, want %016x", ins.Basic, tt.want)
+			}
+			if ins.Opcode() != tt.opcode || ins.Regs() != tt.regs || ins.Offset() != tt.offset || ins.Imm() != tt.imm {
+				t.Errorf("decoded fields do not match the encoded ones")
+			}
+		})
+	}
+}
+
+func TestEncodeImm64(t *testing.T) {
+	opcode := NewLoadAndStoreOpcode(BPF_LD, BPF_DW, BPF_IMM)
+	ins := EncodeImm64(opcode, NewRegs(BPF_R1, BPF_R0), -2)
+	if !ins.Extended64 {
+		t.Fatal("EncodeImm64() did not set a pseudo instruction")
+	}
+	if ins.Basic != 0x18010000feffffff || ins.Pseudo != 0x00000000ffffffff {
+		t.Errorf("EncodeImm64() = %v, want 18010000feffffff 00000000ffffffff", ins)
+	}
+	if ins.Imm64() != -2 {
+		t.Errorf("Imm64() = %d, want -2", ins.Imm64())
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "r1 = 0",
+			data: []byte{0xb7, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "r1 = 0x100000002 ll",
+			data: []byte{
+				0x18, 0x01, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ins Instruction
+			if err := ins.UnmarshalBinary(tt.data); err != nil {
+				t.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+			got, err := ins.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("MarshalBinary() = % x, want % x", got, tt.data)
+			}
+		})
+	}
+
+	t.Run("big-endian", func(t *testing.T) {
+		ld := NewBigEndianInstruction(0x1810000055667788)
+		ld.AddPseudoInstruction(0x0000000011223344)
+		for _, want := range []Instruction{NewBigEndianInstruction(0x63a6fffc00000000), ld} {
+			data, err := want.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+			got := Instruction{BigEndian: true}
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+			if got != want {
+				t.Errorf("UnmarshalBinary() = %+v, want %+v", got, want)
+			}
+			if got.Regs().DstReg() != want.Regs().DstReg() || got.Offset() != want.Offset() || got.Imm64() != want.Imm64() {
+				t.Errorf("UnmarshalBinary() decodes %s %d %d, want %s %d %d", got.Regs().DstReg(), got.Offset(), got.Imm64(), want.Regs().DstReg(), want.Offset(), want.Imm64())
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		var ins Instruction
+		if err := ins.UnmarshalBinary([]byte{0xb7, 0x01}); err == nil {
+			t.Error("UnmarshalBinary() expected an error on a short input")
+		}
+		if err := ins.UnmarshalBinary([]byte{0x18, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err == nil {
+			t.Error("UnmarshalBinary() expected an error on a missing pseudo instruction")
+		}
+		data := make([]byte, 16)
+		data[0] = 0xb7
+		if err := ins.UnmarshalBinary(data); err == nil {
+			t.Error("UnmarshalBinary() expected an error on an unexpected pseudo instruction")
+		}
+	})
+}
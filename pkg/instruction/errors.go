@@ -0,0 +1,29 @@
+package instruction
+
+import "fmt"
+
+// DecodeError is returned for instructions whose encoding cannot be decoded,
+// because it is invalid or not supported yet.
+type DecodeError struct {
+	// Index is the instruction number in its program, -1 when the instruction
+	// was decoded on its own
+	Index int
+	// Raw is the first 8 bytes of the instruction
+	Raw    uint64
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("ins 0x%016x: %s", e.Raw, e.Reason)
+	}
+	return fmt.Sprintf("ins %d (0x%016x): %s", e.Index, e.Raw, e.Reason)
+}
+
+func decodeErrorf(ins Instruction, format string, a ...any) *DecodeError {
+	return &DecodeError{
+		Index:  -1,
+		Raw:    ins.Basic,
+		Reason: fmt.Sprintf(format, a...),
+	}
+}
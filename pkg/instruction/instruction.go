@@ -14,9 +14,12 @@ type OpcodeCode interface {
 	String() string
 }
 
-func (o Opcode) Code() OpcodeCode {
-	typedOpcode := o.ToTyped()
-	return typedOpcode.CodeOrMode()
+func (o Opcode) Code() (OpcodeCode, error) {
+	typedOpcode, err := o.ToTyped()
+	if err != nil {
+		return nil, err
+	}
+	return typedOpcode.CodeOrMode(), nil
 }
 
 type OpcodeClass uint8
@@ -54,14 +57,14 @@ const (
 
 // Type is whether an opcode is a load and store instruction or an arithmetic
 // and jump instruction
-func (c OpcodeClass) Type() OpcodeType {
+func (c OpcodeClass) Type() (OpcodeType, error) {
 	switch c {
 	case BPF_LD, BPF_LDX, BPF_ST, BPF_STX:
-		return LOAD_AND_STORE
+		return LOAD_AND_STORE, nil
 	case BPF_ALU, BPF_JMP, BPF_JMP32, BPF_ALU64:
-		return ARITHMETIC_AND_JUMP
+		return ARITHMETIC_AND_JUMP, nil
 	default:
-		panic("invalid opcode type")
+		return 0, fmt.Errorf("invalid opcode class %s", c)
 	}
 }
 
@@ -69,16 +72,16 @@ type TypedOpcode interface {
 	CodeOrMode() OpcodeCode
 }
 
-func (o Opcode) ToTyped() TypedOpcode {
+func (o Opcode) ToTyped() (TypedOpcode, error) {
 	switch o.Class() {
 	case BPF_ALU, BPF_ALU64:
-		return ArithmeticOpcode(o)
+		return ArithmeticOpcode(o), nil
 	case BPF_JMP, BPF_JMP32:
-		return JumpOpcode(o)
+		return JumpOpcode(o), nil
 	case BPF_LD, BPF_LDX, BPF_ST, BPF_STX:
-		return LoadAndStoreOpcode(o)
+		return LoadAndStoreOpcode(o), nil
 	default:
-		panic("invalid opcode type")
+		return nil, fmt.Errorf("invalid opcode class %s", o.Class())
 	}
 }
 
@@ -108,6 +111,13 @@ const (
 	BPF_X OpcodeSource = 0x08
 )
 
+const (
+	// convert between host byte order and little endian, BPF_END only
+	BPF_TO_LE OpcodeSource = 0x00
+	// convert between host byte order and big endian, BPF_END only
+	BPF_TO_BE OpcodeSource = 0x08
+)
+
 func (o JumpOpcode) Source() OpcodeSource {
 	return OpcodeSource(o & 0b1000)
 }
@@ -229,8 +239,9 @@ func (o LoadAndStoreOpcode) CodeOrMode() OpcodeCode {
 	return o.Mode()
 }
 
+// ImmSrc is the kind of 64-bit immediate load, stored in the src_reg field
 func (ins Instruction) ImmSrc() ImmSource {
-	return ImmSource(ins.Regs().SrcReg() & 0xF0 >> 4)
+	return ImmSource(ins.Regs().SrcReg())
 }
 
 type OpcodeSize uint8
@@ -305,7 +316,12 @@ type Regs uint8
 
 // Regs is composed of the source and the destination register numbers
 func (ins Instruction) Regs() Regs {
-	return Regs(ins.Basic & 0x00FF_0000_0000_0000 >> 48)
+	regs := uint8(ins.Basic & 0x00FF_0000_0000_0000 >> 48)
+	if ins.BigEndian {
+		// the destination register is in the high nibble
+		regs = regs<<4 | regs>>4
+	}
+	return Regs(regs)
 }
 
 type Register uint8
@@ -335,6 +351,26 @@ const (
 	BPF_R10 // r10
 )
 
+// Validate returns an error for the registers out of the r0-r10 range, that
+// the 4 bits of the register fields can encode
+func (r Register) Validate() error {
+	if r > BPF_R10 {
+		return fmt.Errorf("register %d is out of the r0-r10 range", uint8(r))
+	}
+	return nil
+}
+
+var subRegisterNames = [...]string{"w0", "w1", "w2", "w3", "w4", "w5", "w6", "w7", "w8", "w9", "w10"}
+
+// SubRegister is the name of the lower 32 bits of the register, used by the
+// 32-bit operations that zero-extend their result into the upper 32 bits.
+func (r Register) SubRegister() (string, error) {
+	if err := r.Validate(); err != nil {
+		return "", err
+	}
+	return subRegisterNames[r], nil
+}
+
 // SrcReg is the source register number (0-10), except where otherwise specified.
 func (r Regs) SrcReg() Register {
 	return Register(r & 0xF0 >> 4)
@@ -382,6 +418,9 @@ type Offset int16
 
 // Offset is the signed integer offset used with pointer arithmetic
 func (ins Instruction) Offset() Offset {
+	if ins.BigEndian {
+		return Offset(ins.Basic & 0x0000_FFFF_0000_0000 >> 32)
+	}
 	// it's little endian
 	msb := ins.Basic & 0x0000_FF00_0000_0000 >> 40
 	lsb := ins.Basic & 0x0000_00FF_0000_0000 >> 24
@@ -392,6 +431,9 @@ type Imm int32
 
 // Imm is the signed integer immediate value
 func (ins Instruction) Imm() Imm {
+	if ins.BigEndian {
+		return Imm(ins.Basic & 0x0000_0000_FFFF_FFFF)
+	}
 	// it's little endian
 	b1 := ins.Basic & 0x0000_0000_FF00_0000 >> 24
 	b2 := ins.Basic & 0x0000_0000_00FF_0000 >> 8
@@ -401,6 +443,9 @@ func (ins Instruction) Imm() Imm {
 }
 
 func (ins Instruction) NextImm() Imm {
+	if ins.BigEndian {
+		return Imm(ins.Pseudo & 0x0000_0000_FFFF_FFFF)
+	}
 	// it's little endian
 	b1 := ins.Pseudo & 0x0000_0000_FF00_0000 >> 24
 	b2 := ins.Pseudo & 0x0000_0000_00FF_0000 >> 8
@@ -412,13 +457,17 @@ func (ins Instruction) NextImm() Imm {
 type Imm64 int64
 
 func (ins Instruction) Imm64() Imm64 {
-	return Imm64((int64(ins.NextImm()) << 32) | int64(ins.Imm()))
+	return Imm64((int64(ins.NextImm()) << 32) | int64(uint32(ins.Imm())))
 }
 
 type Instruction struct {
 	Basic      uint64
 	Pseudo     uint64
 	Extended64 bool
+	// BigEndian is set for the instructions of big-endian programs, Basic and
+	// Pseudo keep the bytes in memory order but the register nibbles are
+	// swapped and the offset and imm are big-endian.
+	BigEndian bool
 }
 
 func (ins Instruction) String() string {
@@ -434,8 +483,19 @@ func NewInstruction(ins uint64) Instruction {
 	}
 }
 
+// NewBigEndianInstruction is NewInstruction for the instructions of
+// big-endian programs
+func NewBigEndianInstruction(ins uint64) Instruction {
+	return Instruction{
+		Basic:     ins,
+		BigEndian: true,
+	}
+}
+
+// NeedPseudoInstruction is whether the instruction is a 64-bit immediate load
+// that spans over the next instruction slot
 func (ins Instruction) NeedPseudoInstruction() bool {
-	return ins.Opcode().ToTyped().CodeOrMode() == BPF_IMM
+	return ins.Opcode().Class() == BPF_LD && LoadAndStoreOpcode(ins.Opcode()).Mode() == BPF_IMM
 }
 
 func (ins *Instruction) AddPseudoInstruction(pseudoIns uint64) {
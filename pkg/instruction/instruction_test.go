@@ -1,6 +1,7 @@
 package instruction
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -239,6 +240,50 @@ func TestDecodeInstruction(t *testing.T) {
 	})
 }
 
+func TestDecodeBigEndianInstruction(t *testing.T) {
+	// *(u32 *)(r10 - 4) = r6 and r2 += -4 as laid out in a bpfeb object
+	store := NewBigEndianInstruction(0x63a6fffc00000000)
+	if store.Opcode() != 0x63 || store.Regs().DstReg() != BPF_R10 || store.Regs().SrcReg() != BPF_R6 || store.Offset() != -4 {
+		t.Errorf("got opcode 0x%x, dst %s, src %s and offset %d", store.Opcode(), store.Regs().DstReg(), store.Regs().SrcReg(), store.Offset())
+	}
+	add := NewBigEndianInstruction(0x07200000fffffffc)
+	if add.Regs().DstReg() != BPF_R2 || add.Imm() != -4 {
+		t.Errorf("got dst %s and imm %d", add.Regs().DstReg(), add.Imm())
+	}
+
+	ld := NewBigEndianInstruction(0x1810000055667788)
+	ld.AddPseudoInstruction(0x0000000011223344)
+	if ld.Imm64() != 0x1122334455667788 {
+		t.Errorf("got imm64 0x%x, want 0x1122334455667788", ld.Imm64())
+	}
+
+	// the setters write the fields in the byte order of the instruction
+	encoded := Instruction{BigEndian: true}
+	encoded.SetOpcode(store.Opcode())
+	encoded.SetRegs(NewRegs(BPF_R10, BPF_R6))
+	encoded.SetOffset(-4)
+	if encoded.Basic != store.Basic {
+		t.Errorf("got 0x%016x, want 0x%016x", encoded.Basic, store.Basic)
+	}
+	encoded = Instruction{BigEndian: true}
+	encoded.SetImm64(0x1122334455667788)
+	if encoded.Basic != 0x55667788 || encoded.Pseudo != 0x11223344 {
+		t.Errorf("got 0x%016x 0x%016x", encoded.Basic, encoded.Pseudo)
+	}
+}
+
+func TestRegisterSubRegister(t *testing.T) {
+	for r := BPF_R0; r <= BPF_R10; r++ {
+		name, err := r.SubRegister()
+		if want := fmt.Sprintf("w%d", r); err != nil || name != want {
+			t.Errorf("%s.SubRegister() = %q, %v, want %q", r, name, err, want)
+		}
+	}
+	if name, err := Register(14).SubRegister(); err == nil {
+		t.Errorf("Register(14).SubRegister() = %q, want an error", name)
+	}
+}
+
 func TestStringer(t *testing.T) {
 	ins := NewInstruction(exampleInstruction)
 	t.Log(ins.Opcode().Code())
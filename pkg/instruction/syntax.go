@@ -0,0 +1,53 @@
+package instruction
+
+import "github.com/mtardy/mahebpf/pkg/helper"
+
+// Syntax renders the instructions as text, in the style of a tool
+type Syntax interface {
+	// Name is the name of the syntax, used to select it
+	Name() string
+	// Format renders a decoded instruction
+	Format(d Decoded, opts DisassembleOptions) string
+	// FormatSymbol renders a 64-bit immediate load of the address of symbol,
+	// the immediate holds the offset from the symbol
+	FormatSymbol(d Decoded, symbol string, isMap bool) string
+	// FormatCall renders a helper call along with the values of its
+	// arguments, like r1=ctx
+	FormatCall(d Decoded, h helper.Helper, args []string) string
+}
+
+var (
+	// LLVMSyntax is the C-like syntax of llvm-objdump, the default one
+	LLVMSyntax Syntax = llvmSyntax{}
+	// BpftoolSyntax is the syntax of bpftool prog dump xlated, the C-like
+	// syntax prefixed by the opcode, like (b7) r1 = 0
+	BpftoolSyntax Syntax = bpftoolSyntax{}
+	// UbpfSyntax is the mnemonic syntax of the ubpf assembler, like
+	// mov64 r1, 0. The instructions ubpf does not have are written with
+	// these extensions of its mnemonics:
+	//   - the atomic operations other than stxxaddw and stxxadddw are named
+	//     stx followed by the kernel name of the operation and the size, like
+	//     stxorw, stxfetchadddw or stxxchgw
+	//   - stxcmpxchgw and stxcmpxchgdw write the implicit r0 comparand, like
+	//     stxcmpxchgdw [r10-8], r0, r1
+	//   - the 32-bit offset jump of BPF_JMP32 is gotol, like gotol +65536
+	//   - the kernel function calls are call kfunc#id, like call kfunc#12345
+	//   - the signed operations of the v4 instruction set are sdiv, smod,
+	//     movsx, ldxs and bswap, suffixed like the other ones
+	UbpfSyntax Syntax = ubpfSyntax{}
+)
+
+// Syntaxes are the available syntaxes, the default one first
+func Syntaxes() []Syntax {
+	return []Syntax{LLVMSyntax, BpftoolSyntax, UbpfSyntax}
+}
+
+// SyntaxByName returns the syntax with the given name
+func SyntaxByName(name string) (Syntax, bool) {
+	for _, s := range Syntaxes() {
+		if s.Name() == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,226 @@
+package instruction
+
+import (
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
+)
+
+func TestSyntaxes(t *testing.T) {
+	tests := []struct {
+		name    string
+		ins     Instruction
+		llvm    string
+		bpftool string
+		ubpf    string
+	}{
+		{
+			name:    "move imm",
+			ins:     NewInstruction(0xb701000000000000),
+			llvm:    "r1 = 0",
+			bpftool: "(b7) r1 = 0",
+			ubpf:    "mov64 r1, 0",
+		},
+		{
+			name:    "32-bit move",
+			ins:     NewInstruction(0xbc21000000000000),
+			llvm:    "w1 = w2",
+			bpftool: "(bc) w1 = w2",
+			ubpf:    "mov32 r1, r2",
+		},
+		{
+			name:    "signed division",
+			ins:     NewInstruction(0x3f21010000000000),
+			llvm:    "r1 s/= r2",
+			bpftool: "(3f) r1 s/= r2",
+			ubpf:    "sdiv64 r1, r2",
+		},
+		{
+			name:    "sign-extension move",
+			ins:     NewInstruction(0xbf21080000000000),
+			llvm:    "r1 = (s8)r2",
+			bpftool: "(bf) r1 = (s8)r2",
+			ubpf:    "movsx8_64 r1, r2",
+		},
+		{
+			name:    "byte swap",
+			ins:     NewInstruction(0xdc01000010000000),
+			llvm:    "r1 = be16 r1",
+			bpftool: "(dc) r1 = be16 r1",
+			ubpf:    "be16 r1",
+		},
+		{
+			name:    "store",
+			ins:     NewInstruction(0x631afcff00000000),
+			llvm:    "*(u32 *)(r10 - 4) = r1",
+			bpftool: "(63) *(u32 *)(r10 -4) = r1",
+			ubpf:    "stxw [r10-4], r1",
+		},
+		{
+			name:    "load",
+			ins:     NewInstruction(0x7112040000000000),
+			llvm:    "r2 = *(u8 *)(r1 + 4)",
+			bpftool: "(71) r2 = *(u8 *)(r1 +4)",
+			ubpf:    "ldxb r2, [r1+4]",
+		},
+		{
+			name:    "packet load",
+			ins:     NewInstruction(0x300000000e000000),
+			llvm:    "r0 = *(u8 *)skb[14]",
+			bpftool: "(30) r0 = *(u8 *)skb[14]",
+			ubpf:    "ldabsb 14",
+		},
+		{
+			name:    "atomic add",
+			ins:     NewInstruction(0xc31af8ff00000000),
+			llvm:    "*(u32 *)(r10 - 8) += w1",
+			bpftool: "(c3) lock *(u32 *)(r10 -8) += r1",
+			ubpf:    "stxxaddw [r10-8], r1",
+		},
+		{
+			name:    "atomic fetch add",
+			ins:     NewInstruction(0xdb1af8ff01000000),
+			llvm:    "r1 = atomic_fetch_add((u64 *)(r10 - 8), r1)",
+			bpftool: "(db) r1 = atomic64_fetch_add((u64 *)(r10 -8), r1)",
+			ubpf:    "stxfetchadddw [r10-8], r1",
+		},
+		{
+			name:    "atomic or",
+			ins:     NewInstruction(0xc31af8ff40000000),
+			llvm:    "*(u32 *)(r10 - 8) |= w1",
+			bpftool: "(c3) lock *(u32 *)(r10 -8) |= r1",
+			ubpf:    "stxorw [r10-8], r1",
+		},
+		{
+			name:    "atomic exchange",
+			ins:     NewInstruction(0xdb1af8ffe1000000),
+			llvm:    "r1 = xchg_64(r10 - 8, r1)",
+			bpftool: "(db) r1 = atomic64_xchg((u64 *)(r10 -8), r1)",
+			ubpf:    "stxxchgdw [r10-8], r1",
+		},
+		{
+			name:    "atomic compare and exchange",
+			ins:     NewInstruction(0xdb1af8fff1000000),
+			llvm:    "r0 = cmpxchg_64(r10 - 8, r0, r1)",
+			bpftool: "(db) r0 = atomic64_cmpxchg((u64 *)(r10 -8), r0, r1)",
+			ubpf:    "stxcmpxchgdw [r10-8], r0, r1",
+		},
+		{
+			name: "64-bit immediate load",
+			ins: Instruction{
+				Basic:      0x1801000011223344,
+				Pseudo:     0x0000000001000000,
+				Extended64: true,
+			},
+			llvm:    "r1 = 5439169041 ll",
+			bpftool: "(18) r1 = 0x144332211",
+			ubpf:    "lddw r1, 5439169041",
+		},
+		{
+			name:    "helper call",
+			ins:     NewInstruction(0x850000000e000000),
+			llvm:    "call bpf_get_current_pid_tgid#14",
+			bpftool: "(85) call bpf_get_current_pid_tgid#14",
+			ubpf:    "call 14 # bpf_get_current_pid_tgid",
+		},
+		{
+			name:    "local call",
+			ins:     NewInstruction(0x8510000005000000),
+			llvm:    "call +5",
+			bpftool: "(85) call pc+5",
+			ubpf:    "call +5",
+		},
+		{
+			name:    "kernel function call",
+			ins:     NewInstruction(0x8520000039300000),
+			llvm:    "call kfunc#12345",
+			bpftool: "(85) call kernel-function#12345",
+			ubpf:    "call kfunc#12345",
+		},
+		{
+			name:    "long jump",
+			ins:     NewInstruction(0x0600000000000100),
+			llvm:    "gotol +65536",
+			bpftool: "(06) gotol pc+65536",
+			ubpf:    "gotol +65536",
+		},
+		{
+			name:    "conditional jump",
+			ins:     NewInstruction(0x5500090000000000),
+			llvm:    "if r0 != 0 goto +9",
+			bpftool: "(55) if r0 != 0x0 goto pc+9",
+			ubpf:    "jne r0, 0, +9",
+		},
+		{
+			name:    "32-bit conditional jump",
+			ins:     NewInstruction(0x1601ffff2a000000),
+			llvm:    "if w1 == 42 goto -1",
+			bpftool: "(16) if w1 == 0x2a goto pc-1",
+			ubpf:    "jeq32 r1, 42, -1",
+		},
+		{
+			name:    "exit",
+			ins:     NewInstruction(0x9500000000000000),
+			llvm:    "exit",
+			bpftool: "(95) exit",
+			ubpf:    "exit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := map[Syntax]string{LLVMSyntax: tt.llvm, BpftoolSyntax: tt.bpftool, UbpfSyntax: tt.ubpf}
+			for _, syntax := range Syntaxes() {
+				got, err := tt.ins.DisassembleWith(DisassembleOptions{Helpers: helper.Default(), Syntax: syntax})
+				if err != nil {
+					t.Fatalf("DisassembleWith(%s) error = %v", syntax.Name(), err)
+				}
+				if got != want[syntax] {
+					t.Errorf("DisassembleWith(%s) = %q, want %q", syntax.Name(), got, want[syntax])
+				}
+			}
+		})
+	}
+}
+
+func TestSyntaxByName(t *testing.T) {
+	for _, syntax := range Syntaxes() {
+		if got, ok := SyntaxByName(syntax.Name()); !ok || got != syntax {
+			t.Errorf("SyntaxByName(%q) = %v, %v", syntax.Name(), got, ok)
+		}
+	}
+	if _, ok := SyntaxByName("att"); ok {
+		t.Errorf("SyntaxByName(%q) found a syntax", "att")
+	}
+}
+
+func TestSyntaxFormatSymbolAndCall(t *testing.T) {
+	lddw, err := Instruction{Basic: 0x1801000008000000, Extended64: true}.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, err := NewInstruction(0x8500000001000000).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := helper.Default().Lookup(1)
+	args := []string{"r1=map", "r2=fp-4"}
+
+	tests := []struct {
+		syntax Syntax
+		symbol string
+		call   string
+	}{
+		{LLVMSyntax, "r1 = counter + 8 ll", "call bpf_map_lookup_elem#1(r1=map, r2=fp-4)"},
+		{BpftoolSyntax, "(18) r1 = counter+8", "(85) call bpf_map_lookup_elem#1(r1=map, r2=fp-4)"},
+		{UbpfSyntax, "lddw r1, counter + 8", "call 1 # bpf_map_lookup_elem(r1=map, r2=fp-4)"},
+	}
+	for _, tt := range tests {
+		if got := tt.syntax.FormatSymbol(lddw, "counter", false); got != tt.symbol {
+			t.Errorf("%s FormatSymbol() = %q, want %q", tt.syntax.Name(), got, tt.symbol)
+		}
+		if got := tt.syntax.FormatCall(call, h, args); got != tt.call {
+			t.Errorf("%s FormatCall() = %q, want %q", tt.syntax.Name(), got, tt.call)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package instruction
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
+)
+
+// ubpfSyntax is the mnemonic syntax of the ubpf and rbpf assemblers, like
+// ldxw r1, [r2+4]. The operations are suffixed by their width and the jump
+// offsets are relative to the next instruction. The mnemonics of the
+// instructions ubpf does not have are listed with UbpfSyntax.
+type ubpfSyntax struct{}
+
+func (ubpfSyntax) Name() string {
+	return "ubpf"
+}
+
+func (ubpfSyntax) Format(d Decoded, opts DisassembleOptions) string {
+	text := ubpfText(d, ubpfOperands(d))
+	if d.Kind != KindCall {
+		return text
+	}
+	if name, ok := helperName(d.Operands[0], opts); ok {
+		text += " # " + name
+	}
+	return text
+}
+
+func (ubpfSyntax) FormatSymbol(d Decoded, symbol string, isMap bool) string {
+	value := symbol
+	switch offset := d.Operands[1].Imm; {
+	case isMap:
+		value = fmt.Sprintf("map_by_fd(%s)", symbol)
+	case offset != 0:
+		value = fmt.Sprintf("%s + %d", symbol, offset)
+	}
+	return ubpfText(d, []string{d.Operands[0].Reg.String(), value})
+}
+
+func (ubpfSyntax) FormatCall(d Decoded, h helper.Helper, args []string) string {
+	return fmt.Sprintf("%s # %s(%s)", ubpfText(d, ubpfOperands(d)), h.Name, strings.Join(args, ", "))
+}
+
+// ubpfText writes the mnemonic followed by the operands
+func ubpfText(d Decoded, operands []string) string {
+	mnemonic := d.Mnemonic()
+	if len(operands) == 0 {
+		return mnemonic
+	}
+	return mnemonic + " " + strings.Join(operands, ", ")
+}
+
+// ubpfOperands renders the operands, the registers are always named after
+// their 64 bits as the mnemonic tells the width
+func ubpfOperands(d Decoded) []string {
+	var out []string
+	for _, o := range d.Operands {
+		switch o.Kind {
+		case OperandRegister:
+			// the comparand of cmpxchg is implicit in the encoding only
+			if d.Kind == KindAtomic && d.Operation == "cmpxchg" {
+				out = append(out, BPF_R0.String())
+			}
+			out = append(out, o.Reg.String())
+		case OperandImmediate:
+			out = append(out, pseudoImm(o))
+		case OperandMemory:
+			out = append(out, fmt.Sprintf("[%s%+d]", o.Reg, o.Offset))
+		case OperandPacket:
+			if o.Indirect {
+				out = append(out, o.Reg.String())
+			}
+			out = append(out, fmt.Sprint(o.Imm))
+		case OperandTarget:
+			out = append(out, fmt.Sprintf("%+d", o.Offset))
+		case OperandKfunc:
+			out = append(out, strings.TrimPrefix(kfuncCall(o), "call "))
+		default:
+			out = append(out, fmt.Sprint(o.Imm))
+		}
+	}
+	return out
+}
@@ -0,0 +1,219 @@
+package program
+
+import (
+	"fmt"
+
+	"github.com/mtardy/mahebpf/pkg/helper"
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+// registerValue describes what a register holds, as far as it can be told
+// from the instructions of the current basic block
+type registerValue struct {
+	known bool
+	// stack pointers are tracked by their offset to the frame pointer
+	stack  bool
+	offset int64
+	desc   string
+}
+
+func describedValue(format string, a ...any) registerValue {
+	return registerValue{known: true, desc: fmt.Sprintf(format, a...)}
+}
+
+func (v registerValue) String() string {
+	switch {
+	case !v.known:
+		return "?"
+	case v.stack && v.offset == 0:
+		return "fp"
+	case v.stack:
+		return fmt.Sprintf("fp%+d", v.offset)
+	default:
+		return v.desc
+	}
+}
+
+type registerValues [instruction.BPF_R10 + 1]registerValue
+
+func newRegisterValues() registerValues {
+	var values registerValues
+	values[instruction.BPF_R10] = registerValue{known: true, stack: true}
+	return values
+}
+
+func (values *registerValues) get(r instruction.Register) registerValue {
+	if r > instruction.BPF_R10 {
+		return registerValue{}
+	}
+	return values[r]
+}
+
+func (values *registerValues) set(r instruction.Register, v registerValue) {
+	// r10 is read-only and out of range registers are rejected by the verifier
+	if r < instruction.BPF_R10 {
+		values[r] = v
+	}
+}
+
+func (values *registerValues) arithmetic(d instruction.Decoded) registerValue {
+	// 32-bit operations truncate the pointers, and negations have no source
+	if d.Width == 32 && d.Kind != instruction.KindMove || len(d.Operands) < 2 {
+		return registerValue{}
+	}
+	dst, src := values.get(d.Operands[0].Reg), d.Operands[1]
+
+	switch {
+	case d.Kind == instruction.KindMove && src.Kind == instruction.OperandImmediate:
+		return describedValue("%d", src.Imm)
+	case d.Kind == instruction.KindMove && !src.Signed && d.Width == 64:
+		return values.get(src.Reg)
+	case d.Operation == "add" && src.Kind == instruction.OperandImmediate && dst.stack:
+		dst.offset += src.Imm
+		return dst
+	case d.Operation == "sub" && src.Kind == instruction.OperandImmediate && dst.stack:
+		dst.offset -= src.Imm
+		return dst
+	default:
+		return registerValue{}
+	}
+}
+
+func immValue(ins instruction.Instruction) registerValue {
+	switch ins.ImmSrc() {
+	case instruction.BPF_IMM0:
+		return describedValue("%d", ins.Imm64())
+	case instruction.BPF_IMM1, instruction.BPF_IMM5:
+		return describedValue("map")
+	case instruction.BPF_IMM2, instruction.BPF_IMM6:
+		return describedValue("map_value")
+	default:
+		return registerValue{}
+	}
+}
+
+// relocatedValue names the value loaded by a relocated 64-bit immediate load
+func relocatedValue(ins ProgramInstruction) registerValue {
+	if offset := ins.Instruction.Imm64(); offset != 0 && !ins.Relocation.IsMap() {
+		return describedValue("%s+%d", ins.Relocation.Symbol, offset)
+	}
+	return describedValue("%s", ins.Relocation.Symbol)
+}
+
+// update applies the effect of the instruction on the registers
+func (values *registerValues) update(d instruction.Decoded) {
+	switch d.Kind {
+	case instruction.KindArithmetic, instruction.KindMove:
+		values.set(d.Operands[0].Reg, values.arithmetic(d))
+	case instruction.KindLoadImm64:
+		values.set(d.Operands[0].Reg, immValue(d.Instruction))
+	default:
+		for _, r := range d.Writes() {
+			values.set(r, registerValue{})
+		}
+	}
+}
+
+// target returns the instruction number a jump or a local call lands on, the
+// offsets are relative to the next instruction
+func target(number int, d instruction.Decoded) (int, bool) {
+	for _, o := range d.Operands {
+		if o.Kind == instruction.OperandTarget {
+			return number + 1 + int(o.Offset), true
+		}
+	}
+	return 0, false
+}
+
+// jumpTarget is target for an instruction that is not decoded yet
+func jumpTarget(ins ProgramInstruction) (int, bool) {
+	decoded, err := ins.Instruction.Decode()
+	if err != nil {
+		return 0, false
+	}
+	return target(ins.Number, decoded)
+}
+
+// endsBlock reports whether the instruction is a jump or an exit, after which
+// the next instruction starts a new basic block
+func endsBlock(ins instruction.Instruction) bool {
+	typed, err := ins.Opcode().ToTyped()
+	if err != nil {
+		return false
+	}
+	op, ok := typed.(instruction.JumpOpcode)
+	return ok && op.Code() != instruction.BPF_CALL
+}
+
+var helperCallOpcode = instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_CALL, instruction.BPF_K)
+
+// callAnnotator renders the helper calls with the values of their argument
+// registers, tracked within the basic blocks of the program
+type callAnnotator struct {
+	helpers *helper.Table
+	syntax  instruction.Syntax
+	// blockStarts are the instruction numbers that are jumped to
+	blockStarts map[int]bool
+	values      registerValues
+}
+
+func (p Program) newCallAnnotator(opts instruction.DisassembleOptions) *callAnnotator {
+	a := &callAnnotator{
+		helpers:     opts.Helpers,
+		syntax:      opts.Syntax,
+		blockStarts: map[int]bool{},
+		values:      newRegisterValues(),
+	}
+	// programs are entered with their context in r1
+	a.values[instruction.BPF_R1] = describedValue("ctx")
+	for _, ins := range p.Instructions {
+		if target, ok := jumpTarget(ins); ok {
+			a.blockStarts[target] = true
+		}
+	}
+	return a
+}
+
+func (a *callAnnotator) helperCall(ins instruction.Instruction) (helper.Helper, bool) {
+	if a.helpers == nil || ins.Opcode() != helperCallOpcode || ins.Regs().SrcReg() != 0 {
+		return helper.Helper{}, false
+	}
+	return a.helpers.Lookup(int32(ins.Imm()))
+}
+
+// annotate returns the disassembled instruction, with the arguments of the
+// known helper calls, and the arguments alone. It tracks the effect of the
+// instruction.
+func (a *callAnnotator) annotate(ins ProgramInstruction, decoded instruction.Decoded, disassembled string) (string, []string) {
+	if a.blockStarts[ins.Number] {
+		a.values = newRegisterValues()
+	}
+
+	var args []string
+	if h, ok := a.helperCall(ins.Instruction); ok && len(h.Args) > 0 {
+		argc := len(h.Args)
+		if h.Variadic() || argc > 5 {
+			argc = 5
+		}
+		for i := 0; i < argc; i++ {
+			r := instruction.BPF_R1 + instruction.Register(i)
+			args = append(args, fmt.Sprintf("%s=%s", r, a.values[r]))
+		}
+		disassembled = a.syntax.FormatCall(decoded, h, args)
+	}
+
+	a.values.update(decoded)
+	if ins.Relocation != nil && ins.Instruction.Extended64 {
+		a.values.set(ins.Instruction.Regs().DstReg(), relocatedValue(ins))
+	}
+	if endsBlock(ins.Instruction) {
+		a.values = newRegisterValues()
+	}
+	return disassembled, args
+}
+
+// skip forgets the register values after an instruction that could not be
+// decoded
+func (a *callAnnotator) skip() {
+	a.values = newRegisterValues()
+}
@@ -0,0 +1,47 @@
+package program
+
+import (
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+func TestAnnotateHelperCalls(t *testing.T) {
+	b := NewBuilder()
+	b.Mov64Reg(instruction.BPF_R6, instruction.BPF_R1)
+	b.Mov64Reg(instruction.BPF_R2, instruction.BPF_R10)
+	b.ALU64Imm(instruction.BPF_ADD, instruction.BPF_R2, -4)
+	b.LoadMapFD(instruction.BPF_R1, 3)
+	b.Call(1)
+	b.JEQ(instruction.BPF_R0, 0, "out")
+	b.Mov64Reg(instruction.BPF_R1, instruction.BPF_R6)
+	b.Mov64Reg(instruction.BPF_R3, instruction.BPF_R0)
+	b.Mov64Imm(instruction.BPF_R4, 8)
+	b.Call(25)
+	b.Label("out")
+	b.Call(14)
+	b.Call(2)
+	b.Mov64Imm(instruction.BPF_R0, 0)
+	b.Exit()
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+	want := map[int]string{
+		5: "call bpf_map_lookup_elem#1(r1=map, r2=fp-4)",
+		// the values do not survive the conditional jump ending the block
+		10: "call bpf_perf_event_output#25(r1=?, r2=?, r3=?, r4=8, r5=?)",
+		11: "call bpf_get_current_pid_tgid#14",
+		12: "call bpf_map_update_elem#2(r1=?, r2=?, r3=?, r4=?)",
+	}
+	for _, ins := range disassembled {
+		if w, ok := want[ins.InsNumber]; ok && ins.Disassembled != w {
+			t.Errorf("instruction %d = %q, want %q", ins.InsNumber, ins.Disassembled, w)
+		}
+	}
+}
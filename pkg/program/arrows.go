@@ -0,0 +1,163 @@
+package program
+
+import (
+	"regexp"
+	"sort"
+)
+
+// reArrows matches the gutter of the jump arrows drawn on the left of the
+// listings
+var reArrows = regexp.MustCompile("^[ ,`|+>-]+")
+
+// StripArrows removes the gutter of the jump arrows from a line of a listing
+func StripArrows(line string) string {
+	return reArrows.ReplaceAllString(line, "")
+}
+
+// arrow is a jump between two lines of the listing, drawn in a lane of the
+// gutter
+type arrow struct {
+	from, to int
+	lane     int
+}
+
+func (a arrow) top() int {
+	return min(a.from, a.to)
+}
+
+func (a arrow) bottom() int {
+	return max(a.from, a.to)
+}
+
+// Arrows draws the jumps and local calls between the disassembled instructions
+// as ASCII arrows in a gutter, like objdump --visualize-jumps. The arrows
+// start from a ` or , corner on the jumping instruction and end with a > on
+// their target, backward edges of the loops going up:
+//
+//	  0: r0 = 1
+//	,>1: r0 += 1
+//	`-2: if r0 < 5 goto -2
+//
+// The shorter arrows are drawn the closest to the instructions, and the jumps
+// to instructions out of the listing are left out.
+type Arrows struct {
+	arrows []arrow
+	lanes  int
+}
+
+// NewArrows lays out the arrows of the jumps between the instructions
+func NewArrows(disassembled []DisassembledProgram) Arrows {
+	lines := make(map[int]int, len(disassembled))
+	for i, ins := range disassembled {
+		lines[ins.InsNumber] = i
+	}
+
+	var arrows []arrow
+	for i, ins := range disassembled {
+		target, ok := ins.Target()
+		if !ok {
+			continue
+		}
+		if to, ok := lines[target]; ok {
+			arrows = append(arrows, arrow{from: i, to: to})
+		}
+	}
+	sort.SliceStable(arrows, func(i, j int) bool {
+		return arrows[i].bottom()-arrows[i].top() < arrows[j].bottom()-arrows[j].top()
+	})
+
+	// the arrows get the innermost lane where they do not cross the lines of
+	// another arrow
+	var a Arrows
+	var taken [][]arrow
+	for _, arw := range arrows {
+		lane := 0
+		for ; lane < len(taken); lane++ {
+			if !overlaps(taken[lane], arw) {
+				break
+			}
+		}
+		if lane == len(taken) {
+			taken = append(taken, nil)
+		}
+		arw.lane = lane
+		taken[lane] = append(taken[lane], arw)
+		a.arrows = append(a.arrows, arw)
+	}
+	a.lanes = len(taken)
+	return a
+}
+
+func overlaps(lane []arrow, arw arrow) bool {
+	for _, other := range lane {
+		if arw.top() <= other.bottom() && other.top() <= arw.bottom() {
+			return true
+		}
+	}
+	return false
+}
+
+// Line returns the gutter of the line of instruction i, it is empty when there
+// is no arrow to draw
+func (a Arrows) Line(i int) string {
+	if a.lanes == 0 {
+		return ""
+	}
+	// the lanes are drawn from the outermost, on the left
+	cells := make([]byte, a.lanes+1)
+	for j := range cells {
+		cells[j] = ' '
+	}
+	// horizontal is the outermost lane with an arrow starting or ending on
+	// the line, the line runs from it to the instruction
+	horizontal := len(cells)
+	end := byte(' ')
+	for _, arw := range a.arrows {
+		cell := a.lanes - 1 - arw.lane
+		switch {
+		case i == arw.top():
+			cells[cell] = ','
+		case i == arw.bottom():
+			cells[cell] = '`'
+		case i > arw.top() && i < arw.bottom():
+			cells[cell] = '|'
+			continue
+		default:
+			continue
+		}
+		horizontal = min(horizontal, cell)
+		if i == arw.to {
+			end = '>'
+		} else if end == ' ' {
+			end = '-'
+		}
+	}
+	for j := horizontal + 1; j < a.lanes; j++ {
+		switch cells[j] {
+		case ' ':
+			cells[j] = '-'
+		case '|':
+			cells[j] = '+'
+		}
+	}
+	cells[a.lanes] = end
+	return string(cells) + " "
+}
+
+// Above returns the gutter of a line inserted above the line of instruction i,
+// like a label, where only the arrows passing by are drawn
+func (a Arrows) Above(i int) string {
+	if a.lanes == 0 {
+		return ""
+	}
+	cells := make([]byte, a.lanes+1)
+	for j := range cells {
+		cells[j] = ' '
+	}
+	for _, arw := range a.arrows {
+		if i > arw.top() && i <= arw.bottom() {
+			cells[a.lanes-1-arw.lane] = '|'
+		}
+	}
+	return string(cells) + " "
+}
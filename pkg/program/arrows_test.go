@@ -0,0 +1,123 @@
+package program
+
+import (
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+func TestArrows(t *testing.T) {
+	b := NewBuilder()
+	b.Label("start")
+	b.Mov64Imm(instruction.BPF_R0, 1)
+	b.Label("loop")
+	b.ALU64Imm(instruction.BPF_ADD, instruction.BPF_R0, 1)
+	b.JLT(instruction.BPF_R0, 5, "loop")
+	b.Ja("out")
+	b.LoadImm64(instruction.BPF_R0, 3)
+	b.Label("out")
+	b.CallLocal("start")
+	b.Exit()
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+
+	arrows := NewArrows(disassembled)
+	lines := []string{
+		",-> ",
+		"|,> ",
+		"|`- ",
+		"|,- ",
+		"||  ",
+		"``> ",
+		"    ",
+	}
+	for i, want := range lines {
+		if got := arrows.Line(i); got != want {
+			t.Errorf("Line(%d) = %q, want %q", i, got, want)
+		}
+	}
+	above := map[int]string{0: "    ", 1: "|   ", 5: "||  "}
+	for i, want := range above {
+		if got := arrows.Above(i); got != want {
+			t.Errorf("Above(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestArrowsNested(t *testing.T) {
+	b := NewBuilder()
+	b.JEQ(instruction.BPF_R1, 0, "far")
+	b.JEQ(instruction.BPF_R1, 1, "near")
+	b.Mov64Imm(instruction.BPF_R0, 1)
+	b.Label("near")
+	b.Mov64Imm(instruction.BPF_R0, 2)
+	b.Label("far")
+	b.Exit()
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+
+	// the longer jump runs outside of the shorter one
+	arrows := NewArrows(disassembled)
+	lines := []string{",-- ", "|,- ", "||  ", "|`> ", "`-> "}
+	for i, want := range lines {
+		if got := arrows.Line(i); got != want {
+			t.Errorf("Line(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestArrowsCrossing(t *testing.T) {
+	b := NewBuilder()
+	b.JEQ(instruction.BPF_R1, 0, "first")
+	b.JEQ(instruction.BPF_R1, 1, "second")
+	b.Label("first")
+	b.Mov64Imm(instruction.BPF_R0, 1)
+	b.Label("second")
+	b.Exit()
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+
+	// the second jump starts across the lane of the first one
+	arrows := NewArrows(disassembled)
+	lines := []string{" ,- ", ",+- ", "|`> ", "`-> "}
+	for i, want := range lines {
+		if got := arrows.Line(i); got != want {
+			t.Errorf("Line(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestArrowsWithoutJumps(t *testing.T) {
+	b := NewBuilder()
+	b.Mov64Imm(instruction.BPF_R0, 0)
+	b.Exit()
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+	if got := NewArrows(disassembled).Line(0); got != "" {
+		t.Errorf("Line(0) = %q, want no gutter", got)
+	}
+}
@@ -0,0 +1,138 @@
+package program
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// reAddress matches the instruction number or address column of the listings
+// printed by mahebpf or llvm-objdump, like "  7:" or "0x38:"
+var reAddress = regexp.MustCompile(`^(0[xX])?[0-9a-fA-F]+:$`)
+
+// asciiToken is a word of a line and the column it starts at, counted from 1
+type asciiToken struct {
+	text   string
+	column int
+}
+
+// asciiTokens splits the line on spaces, tabs and commas
+func asciiTokens(line string) []asciiToken {
+	var tokens []asciiToken
+	start := -1
+	for i, c := range line + " " {
+		separator := c == ' ' || c == '\t' || c == ','
+		switch {
+		case separator && start >= 0:
+			tokens = append(tokens, asciiToken{text: line[start:i], column: start + 1})
+			start = -1
+		case !separator && start < 0:
+			start = i
+		}
+	}
+	return tokens
+}
+
+// stripASCIIComment removes the '#', '//' and ';' comments from a line
+func stripASCIIComment(line string) string {
+	for _, marker := range []string{"#", "//", ";"} {
+		if i := strings.Index(line, marker); i >= 0 {
+			line = line[:i]
+		}
+	}
+	return line
+}
+
+// isListingHeader reports whether the line is one of the headers of the
+// listings, which do not hold any instruction
+func isListingHeader(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasSuffix(line, ">:") ||
+		strings.HasPrefix(line, "Disassembly of section ") ||
+		strings.Contains(line, "file format ")
+}
+
+// hexBytes decodes a token of any number of whole bytes, with an optional 0x
+// prefix
+func hexBytes(token string) ([]byte, bool) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(token, "0x"), "0X")
+	if len(digits) == 0 || len(digits)%2 != 0 {
+		return nil, false
+	}
+	data, err := hex.DecodeString(digits)
+	return data, err == nil
+}
+
+// invalidIndex returns the index in the token of its first character that is
+// not an hexadecimal digit, -1 when they all are
+func invalidIndex(token string) int {
+	prefix := 0
+	if strings.HasPrefix(token, "0x") || strings.HasPrefix(token, "0X") {
+		prefix = 2
+	}
+	for i, c := range token[prefix:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return prefix + i
+		}
+	}
+	return -1
+}
+
+// decodeASCII returns the bytes of a program written in hexadecimal. The
+// bytes can be grouped in runs of any length, like b701 0000 or b7010000,
+// split by spaces, tabs or commas, prefixed with 0x and followed by comments. Lines pasted from mahebpf or llvm-objdump listings are
+// accepted, their jump arrows, numbers, labels and disassembled instructions
+// are skipped.
+func decodeASCII(text []byte) ([]byte, error) {
+	var data []byte
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := StripArrows(stripASCIIComment(strings.TrimSuffix(scanner.Text(), "\r")))
+		if isListingHeader(line) || IsLabel(line) {
+			continue
+		}
+
+		tokens := asciiTokens(line)
+		listing := len(tokens) > 0 && reAddress.MatchString(tokens[0].text)
+		if listing {
+			tokens = tokens[1:]
+		}
+
+		var lineData []byte
+		for i, token := range tokens {
+			// the disassembled instruction that follows the bytes of a
+			// listing, told apart from the bytes grouped like be16 by
+			// the change of grouping
+			instructionEnd := len(lineData) > 0 && len(lineData)%8 == 0
+			if instructionEnd && listing && len(token.text) != len(tokens[i-1].text) {
+				break
+			}
+			b, ok := hexBytes(token.text)
+			if ok {
+				lineData = append(lineData, b...)
+				continue
+			}
+			if instructionEnd {
+				break
+			}
+			if i := invalidIndex(token.text); i >= 0 {
+				c, _ := utf8.DecodeRuneInString(token.text[i:])
+				return nil, fmt.Errorf("line %d column %d: invalid character %q", lineNumber, token.column+i, c)
+			}
+			return nil, fmt.Errorf("line %d column %d: %q has an odd number of hexadecimal digits", lineNumber, token.column, token.text)
+		}
+		data = append(data, lineData...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(data)%8 != 0 {
+		return nil, fmt.Errorf("program len %d is not a multiple of 8 bytes", len(data))
+	}
+	return data, nil
+}
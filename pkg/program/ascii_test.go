@@ -0,0 +1,107 @@
+package program
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeASCII(t *testing.T) {
+	want := []byte{
+		0xb7, 0x01, 0, 0, 0, 0, 0, 0,
+		0x18, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x95, 0, 0, 0, 0, 0, 0, 0,
+	}
+	for _, tt := range []struct {
+		name string
+		text string
+	}{
+		{
+			name: "bytes",
+			text: "b7 01 00 00 00 00 00 00\n18 01 00 00 00 00 00 00\n00 00 00 00 00 00 00 00\n95 00 00 00 00 00 00 00\n",
+		},
+		{
+			name: "words",
+			text: "b701000000000000\n1801000000000000 0000000000000000\n9500000000000000",
+		},
+		{
+			name: "split instructions",
+			text: "b7 01 00 00\n00 00 00 00 18 01 00 00 00 00 00 00 00 00 00 00 00 00 00 00 95 00\n00 00 00 00 00 00\n",
+		},
+		{
+			name: "4-digit groups",
+			text: "b701 0000 0000 0000\n1801 0000 0000 0000 0000 0000 0000 0000\n9500 0000 0000 0000\n",
+		},
+		{
+			name: "8-digit groups",
+			text: "b7010000 00000000\n18010000 00000000 00000000 00000000\n95000000 00000000\n",
+		},
+		{
+			name: "groups across instructions",
+			text: "b7010000 0000 0000 1801 00000000 00000000 0000 0000 00 00 950000000000 0000\n",
+		},
+		{
+			name: "ubpf listing",
+			text: "0: b701000000000000 mov64 r1, 0\n1: 1801000000000000 0000000000000000 lddw r1, 0\n3: 9500000000000000 be16 r1\n",
+		},
+		{
+			name: "tabs and CRLF",
+			text: "b7\t01\t00\t00\t00\t00\t00\t00\r\n1801000000000000\t0000000000000000\r\n9500000000000000\r\n",
+		},
+		{
+			name: "comments",
+			text: "# the program\nb701000000000000 // r1 = 0\n1801000000000000 0000000000000000 ; r1 = 0 ll\n\n9500000000000000 # exit\n",
+		},
+		{
+			name: "0x prefixes and commas",
+			text: "0xb7, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,\n0x1801000000000000, 0x0000000000000000,\n0X9500000000000000\n",
+		},
+		{
+			name: "mahebpf listing",
+			text: "Disassembly of section kprobe/pizza:\n\n<pizza>:\n 0: b701000000000000 r1 = 0\n 1: 1801000000000000 0000000000000000 r1 = 0 ll\n 3: 9500000000000000 exit\n",
+		},
+		{
+			name: "labelled listing",
+			text: "<pizza>:\nLBB0_0:\n0: b701000000000000 r1 = 0\nLBB0_1:\n1: 1801000000000000 0000000000000000 r1 = 0 ll\n3: 9500000000000000 exit\n",
+		},
+		{
+			name: "listing with arrows",
+			text: "    <pizza>:\n,-> 0: b701000000000000 r1 = 0\n|   LBB0_1:\n|,> 1: 1801000000000000 0000000000000000 r1 = 0 ll\n``- 3: 9500000000000000 exit\n",
+		},
+		{
+			name: "llvm-objdump listing",
+			text: "prog.o:\tfile format elf64-bpf\n\nDisassembly of section kprobe/pizza:\n\n0000000000000000 <pizza>:\n" +
+				"       0:\tb7 01 00 00 00 00 00 00\tr1 = 0\n" +
+				"       1:\t18 01 00 00 00 00 00 00 00 00 00 00 00 00 00 00\tr1 = 0 ll\n" +
+				"       3:\t95 00 00 00 00 00 00 00\texit\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeASCII([]byte(tt.text))
+			if err != nil {
+				t.Fatalf("decodeASCII() error = %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("decodeASCII() = % x, want % x", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeASCIIErrors(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want string
+	}{
+		{text: "b7 01 0g 00 00 00 00 00", want: "line 1 column 8: invalid character 'g'"},
+		{text: "b701000000000000\n\tzz 00", want: "line 2 column 2: invalid character 'z'"},
+		{text: "b7 01 000 00 00 00 00 00", want: `line 1 column 7: "000" has an odd number of hexadecimal digits`},
+		{text: "b7 01 00 00 r1 = 0", want: "line 1 column 13: invalid character 'r'"},
+		{text: "b7 01 00 00", want: "program len 4 is not a multiple of 8 bytes"},
+	} {
+		_, err := decodeASCII([]byte(tt.text))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("decodeASCII(%q) error = %v, want %q", tt.text, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package program
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// This is a minimal reader of the BTF type information, just enough to decode
+// the map definitions of the .maps section.
+
+const btfMagic = 0xeb9f
+
+type btfKind uint8
+
+const (
+	btfKindInt      btfKind = 1
+	btfKindPtr      btfKind = 2
+	btfKindArray    btfKind = 3
+	btfKindStruct   btfKind = 4
+	btfKindUnion    btfKind = 5
+	btfKindEnum     btfKind = 6
+	btfKindFwd      btfKind = 7
+	btfKindTypedef  btfKind = 8
+	btfKindVolatile btfKind = 9
+	btfKindConst    btfKind = 10
+	btfKindRestrict btfKind = 11
+	btfKindFunc     btfKind = 12
+	btfKindProto    btfKind = 13
+	btfKindVar      btfKind = 14
+	btfKindDatasec  btfKind = 15
+	btfKindFloat    btfKind = 16
+	btfKindDeclTag  btfKind = 17
+	btfKindTypeTag  btfKind = 18
+	btfKindEnum64   btfKind = 19
+)
+
+// btfMember is a struct member or a datasec variable, offset is in bits for
+// members and in bytes for variables
+type btfMember struct {
+	name   string
+	typeID uint32
+	offset uint32
+}
+
+type btfType struct {
+	name string
+	kind btfKind
+	// sizeOrType is the size of the sized kinds and the referenced type of
+	// the others
+	sizeOrType uint32
+	// elemType and nelems describe arrays
+	elemType uint32
+	nelems   uint32
+	members  []btfMember
+}
+
+// btfTypes are indexed by type ID, the ID 0 is void
+type btfTypes []btfType
+
+func parseBTF(data []byte, order binary.ByteOrder) (btfTypes, error) {
+	const headerLen = 24
+	if len(data) < headerLen {
+		return nil, errors.New("BTF is too short for its header")
+	}
+	if order.Uint16(data) != btfMagic {
+		return nil, fmt.Errorf("invalid BTF magic 0x%04x", order.Uint16(data))
+	}
+	hdrLen := order.Uint32(data[4:])
+	typeOff, typeLen := order.Uint32(data[8:]), order.Uint32(data[12:])
+	strOff, strLen := order.Uint32(data[16:]), order.Uint32(data[20:])
+	if uint64(hdrLen)+uint64(typeOff)+uint64(typeLen) > uint64(len(data)) ||
+		uint64(hdrLen)+uint64(strOff)+uint64(strLen) > uint64(len(data)) {
+		return nil, errors.New("BTF sections are out of bounds")
+	}
+	typeData := data[hdrLen+typeOff : hdrLen+typeOff+typeLen]
+	strs := data[hdrLen+strOff : hdrLen+strOff+strLen]
+
+	name := func(off uint32) string {
+		if int(off) >= len(strs) {
+			return ""
+		}
+		end := int(off)
+		for end < len(strs) && strs[end] != 0 {
+			end++
+		}
+		return string(strs[off:end])
+	}
+
+	types := btfTypes{{}}
+	for i := 0; i < len(typeData); {
+		if i+12 > len(typeData) {
+			return nil, errors.New("BTF type is truncated")
+		}
+		info := order.Uint32(typeData[i+4:])
+		t := btfType{
+			name:       name(order.Uint32(typeData[i:])),
+			kind:       btfKind(info >> 24 & 0x1f),
+			sizeOrType: order.Uint32(typeData[i+8:]),
+		}
+		vlen := int(info & 0xffff)
+		i += 12
+
+		// extra is the size of the data following the type
+		var extra int
+		switch t.kind {
+		case btfKindInt, btfKindVar, btfKindDeclTag:
+			extra = 4
+		case btfKindArray:
+			extra = 12
+		case btfKindStruct, btfKindUnion, btfKindDatasec:
+			extra = 12 * vlen
+		case btfKindEnum, btfKindProto:
+			extra = 8 * vlen
+		case btfKindEnum64:
+			extra = 12 * vlen
+		case btfKindPtr, btfKindFwd, btfKindTypedef, btfKindVolatile, btfKindConst,
+			btfKindRestrict, btfKindFunc, btfKindFloat, btfKindTypeTag:
+		default:
+			return nil, fmt.Errorf("unknown BTF kind %d", t.kind)
+		}
+		if i+extra > len(typeData) {
+			return nil, fmt.Errorf("BTF type %d is truncated", len(types))
+		}
+
+		switch t.kind {
+		case btfKindArray:
+			t.elemType = order.Uint32(typeData[i:])
+			t.nelems = order.Uint32(typeData[i+8:])
+		case btfKindStruct, btfKindUnion:
+			for j := 0; j < vlen; j++ {
+				m := typeData[i+12*j:]
+				t.members = append(t.members, btfMember{
+					name:   name(order.Uint32(m)),
+					typeID: order.Uint32(m[4:]),
+					offset: order.Uint32(m[8:]),
+				})
+			}
+		case btfKindDatasec:
+			for j := 0; j < vlen; j++ {
+				m := typeData[i+12*j:]
+				t.members = append(t.members, btfMember{
+					typeID: order.Uint32(m),
+					offset: order.Uint32(m[4:]),
+				})
+			}
+		}
+		i += extra
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func (types btfTypes) get(id uint32) (btfType, error) {
+	if int(id) >= len(types) {
+		return btfType{}, fmt.Errorf("BTF type %d does not exist", id)
+	}
+	return types[id], nil
+}
+
+// resolve skips the typedefs and type qualifiers
+func (types btfTypes) resolve(id uint32) (btfType, error) {
+	for depth := 0; depth < 32; depth++ {
+		t, err := types.get(id)
+		if err != nil {
+			return btfType{}, err
+		}
+		switch t.kind {
+		case btfKindTypedef, btfKindVolatile, btfKindConst, btfKindRestrict, btfKindTypeTag:
+			id = t.sizeOrType
+		default:
+			return t, nil
+		}
+	}
+	return btfType{}, fmt.Errorf("BTF type %d references are too deep", id)
+}
+
+func (types btfTypes) size(id uint32) (uint32, error) {
+	t, err := types.resolve(id)
+	if err != nil {
+		return 0, err
+	}
+	switch t.kind {
+	case btfKindInt, btfKindStruct, btfKindUnion, btfKindEnum, btfKindEnum64, btfKindFloat, btfKindDatasec:
+		return t.sizeOrType, nil
+	case btfKindPtr:
+		return 8, nil
+	case btfKindArray:
+		elem, err := types.size(t.elemType)
+		return elem * t.nelems, err
+	default:
+		return 0, fmt.Errorf("BTF type %d has no size", id)
+	}
+}
+
+// mapDefinitions decodes the map definitions of the .maps section, the fields
+// of the anonymous structs are encoded as pointers to arrays of the value
+// length, and the key and value as pointers to their type. The maps whose
+// definition cannot be decoded are left out.
+func (types btfTypes) mapDefinitions() map[string]MapDefinition {
+	defs := map[string]MapDefinition{}
+	for _, sec := range types {
+		if sec.kind != btfKindDatasec || sec.name != ".maps" {
+			continue
+		}
+		for _, v := range sec.members {
+			variable, err := types.get(v.typeID)
+			if err != nil {
+				continue
+			}
+			def, err := types.mapDefinition(variable.sizeOrType)
+			if err != nil {
+				continue
+			}
+			defs[variable.name] = def
+		}
+	}
+	return defs
+}
+
+func (types btfTypes) mapDefinition(id uint32) (MapDefinition, error) {
+	s, err := types.resolve(id)
+	if err != nil {
+		return MapDefinition{}, err
+	}
+	if s.kind != btfKindStruct {
+		return MapDefinition{}, fmt.Errorf("definition is not a struct but kind %d", s.kind)
+	}
+
+	var def MapDefinition
+	for _, m := range s.members {
+		ptr, err := types.resolve(m.typeID)
+		if err != nil {
+			return MapDefinition{}, err
+		}
+		if ptr.kind != btfKindPtr {
+			continue
+		}
+		switch m.name {
+		case "key", "value":
+			size, err := types.size(ptr.sizeOrType)
+			if err != nil {
+				return MapDefinition{}, err
+			}
+			if m.name == "key" {
+				def.KeySize = size
+			} else {
+				def.ValueSize = size
+			}
+			continue
+		}
+
+		array, err := types.resolve(ptr.sizeOrType)
+		if err != nil {
+			return MapDefinition{}, err
+		}
+		if array.kind != btfKindArray {
+			continue
+		}
+		switch m.name {
+		case "type":
+			def.Type = array.nelems
+		case "key_size":
+			def.KeySize = array.nelems
+		case "value_size":
+			def.ValueSize = array.nelems
+		case "max_entries":
+			def.MaxEntries = array.nelems
+		case "map_flags":
+			def.Flags = array.nelems
+		}
+	}
+	return def, nil
+}
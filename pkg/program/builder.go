@@ -0,0 +1,259 @@
+package program
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+// jumpReference is an instruction waiting for the position of a label to
+// compute its relative jump
+type jumpReference struct {
+	index int
+	label string
+	// call and long jump references store the distance in the imm field
+	// instead of offset
+	imm bool
+}
+
+// Builder writes a program instruction by instruction, jumps target labels
+// that are resolved to relative offsets when the program is built.
+type Builder struct {
+	prog       Program
+	slot       int
+	labels     map[string]int
+	references []jumpReference
+	err        error
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		prog:   NewProgram(),
+		labels: map[string]int{},
+	}
+}
+
+// Raw appends an already encoded instruction
+func (b *Builder) Raw(ins instruction.Instruction) {
+	b.prog.Instructions = append(b.prog.Instructions, ProgramInstruction{
+		Instruction: ins,
+		Number:      b.slot,
+	})
+	b.slot++
+	if ins.Extended64 {
+		b.slot++
+	}
+}
+
+// Label names the position of the next instruction
+func (b *Builder) Label(name string) {
+	if _, ok := b.labels[name]; ok && b.err == nil {
+		b.err = fmt.Errorf("label %q is defined twice", name)
+	}
+	b.labels[name] = b.slot
+}
+
+func (b *Builder) reference(ins instruction.Instruction, label string, imm bool) {
+	b.references = append(b.references, jumpReference{
+		index: len(b.prog.Instructions),
+		label: label,
+		imm:   imm,
+	})
+	b.Raw(ins)
+}
+
+func (b *Builder) ALU64Imm(op instruction.OpcodeArithmetic, dst instruction.Register, imm instruction.Imm) {
+	opcode := instruction.NewArithmeticOpcode(instruction.BPF_ALU64, op, instruction.BPF_K)
+	b.Raw(instruction.Encode(opcode, instruction.NewRegs(dst, 0), 0, imm))
+}
+
+func (b *Builder) ALU64Reg(op instruction.OpcodeArithmetic, dst, src instruction.Register) {
+	opcode := instruction.NewArithmeticOpcode(instruction.BPF_ALU64, op, instruction.BPF_X)
+	b.Raw(instruction.Encode(opcode, instruction.NewRegs(dst, src), 0, 0))
+}
+
+func (b *Builder) ALU32Imm(op instruction.OpcodeArithmetic, dst instruction.Register, imm instruction.Imm) {
+	opcode := instruction.NewArithmeticOpcode(instruction.BPF_ALU, op, instruction.BPF_K)
+	b.Raw(instruction.Encode(opcode, instruction.NewRegs(dst, 0), 0, imm))
+}
+
+func (b *Builder) ALU32Reg(op instruction.OpcodeArithmetic, dst, src instruction.Register) {
+	opcode := instruction.NewArithmeticOpcode(instruction.BPF_ALU, op, instruction.BPF_X)
+	b.Raw(instruction.Encode(opcode, instruction.NewRegs(dst, src), 0, 0))
+}
+
+func (b *Builder) Mov64Imm(dst instruction.Register, imm instruction.Imm) {
+	b.ALU64Imm(instruction.BPF_MOV, dst, imm)
+}
+
+func (b *Builder) Mov64Reg(dst, src instruction.Register) {
+	b.ALU64Reg(instruction.BPF_MOV, dst, src)
+}
+
+func (b *Builder) Mov32Imm(dst instruction.Register, imm instruction.Imm) {
+	b.ALU32Imm(instruction.BPF_MOV, dst, imm)
+}
+
+func (b *Builder) Mov32Reg(dst, src instruction.Register) {
+	b.ALU32Reg(instruction.BPF_MOV, dst, src)
+}
+
+// LoadImm64 appends the two slots dst = imm ll instruction
+func (b *Builder) LoadImm64(dst instruction.Register, imm instruction.Imm64) {
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_LD, instruction.BPF_DW, instruction.BPF_IMM)
+	b.Raw(instruction.EncodeImm64(opcode, instruction.NewRegs(dst, 0), imm))
+}
+
+// LoadMapFD appends the two slots dst = map_by_fd(fd) instruction
+func (b *Builder) LoadMapFD(dst instruction.Register, fd instruction.Imm) {
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_LD, instruction.BPF_DW, instruction.BPF_IMM)
+	regs := instruction.NewRegs(dst, instruction.Register(instruction.BPF_IMM1))
+	b.Raw(instruction.EncodeImm64(opcode, regs, instruction.Imm64(uint32(fd))))
+}
+
+// LoadMem appends dst = *(size *)(src + off)
+func (b *Builder) LoadMem(size instruction.OpcodeSize, dst, src instruction.Register, off instruction.Offset) {
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_LDX, size, instruction.BPF_MEM)
+	b.Raw(instruction.Encode(opcode, instruction.NewRegs(dst, src), off, 0))
+}
+
+// StoreMem appends *(size *)(dst + off) = src
+func (b *Builder) StoreMem(size instruction.OpcodeSize, dst instruction.Register, off instruction.Offset, src instruction.Register) {
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_STX, size, instruction.BPF_MEM)
+	b.Raw(instruction.Encode(opcode, instruction.NewRegs(dst, src), off, 0))
+}
+
+// StoreImm appends *(size *)(dst + off) = imm
+func (b *Builder) StoreImm(size instruction.OpcodeSize, dst instruction.Register, off instruction.Offset, imm instruction.Imm) {
+	opcode := instruction.NewLoadAndStoreOpcode(instruction.BPF_ST, size, instruction.BPF_MEM)
+	b.Raw(instruction.Encode(opcode, instruction.NewRegs(dst, 0), off, imm))
+}
+
+// JumpImm appends a conditional jump to label comparing dst with imm
+func (b *Builder) JumpImm(code instruction.OpcodeJump, dst instruction.Register, imm instruction.Imm, label string) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP, code, instruction.BPF_K)
+	b.reference(instruction.Encode(opcode, instruction.NewRegs(dst, 0), 0, imm), label, false)
+}
+
+// JumpReg appends a conditional jump to label comparing dst with src
+func (b *Builder) JumpReg(code instruction.OpcodeJump, dst, src instruction.Register, label string) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP, code, instruction.BPF_X)
+	b.reference(instruction.Encode(opcode, instruction.NewRegs(dst, src), 0, 0), label, false)
+}
+
+// Jump32Imm appends a conditional jump to label comparing the lower 32 bits of
+// dst with imm
+func (b *Builder) Jump32Imm(code instruction.OpcodeJump, dst instruction.Register, imm instruction.Imm, label string) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP32, code, instruction.BPF_K)
+	b.reference(instruction.Encode(opcode, instruction.NewRegs(dst, 0), 0, imm), label, false)
+}
+
+// Jump32Reg appends a conditional jump to label comparing the lower 32 bits of
+// dst with the ones of src
+func (b *Builder) Jump32Reg(code instruction.OpcodeJump, dst, src instruction.Register, label string) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP32, code, instruction.BPF_X)
+	b.reference(instruction.Encode(opcode, instruction.NewRegs(dst, src), 0, 0), label, false)
+}
+
+func (b *Builder) JEQ(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JEQ, dst, imm, label)
+}
+
+func (b *Builder) JNE(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JNE, dst, imm, label)
+}
+
+func (b *Builder) JGT(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JGT, dst, imm, label)
+}
+
+func (b *Builder) JGE(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JGE, dst, imm, label)
+}
+
+func (b *Builder) JLT(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JLT, dst, imm, label)
+}
+
+func (b *Builder) JLE(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JLE, dst, imm, label)
+}
+
+func (b *Builder) JSGT(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JSGT, dst, imm, label)
+}
+
+func (b *Builder) JSGE(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JSGE, dst, imm, label)
+}
+
+func (b *Builder) JSLT(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JSLT, dst, imm, label)
+}
+
+func (b *Builder) JSLE(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JSLE, dst, imm, label)
+}
+
+func (b *Builder) JSET(dst instruction.Register, imm instruction.Imm, label string) {
+	b.JumpImm(instruction.BPF_JSET, dst, imm, label)
+}
+
+// Ja appends an unconditional jump to label
+func (b *Builder) Ja(label string) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_JA, instruction.BPF_K)
+	b.reference(instruction.Encode(opcode, 0, 0, 0), label, false)
+}
+
+// Gotol appends an unconditional long jump to label, its distance is not
+// limited to the 16-bit offset range
+func (b *Builder) Gotol(label string) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP32, instruction.BPF_JA, instruction.BPF_K)
+	b.reference(instruction.Encode(opcode, 0, 0, 0), label, true)
+}
+
+// Call appends a call to the helper function with the given ID
+func (b *Builder) Call(helper instruction.Imm) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_CALL, instruction.BPF_K)
+	b.Raw(instruction.Encode(opcode, 0, 0, helper))
+}
+
+// CallLocal appends a call to the program-local function starting at label
+func (b *Builder) CallLocal(label string) {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_CALL, instruction.BPF_K)
+	b.reference(instruction.Encode(opcode, instruction.NewRegs(0, 1), 0, 0), label, true)
+}
+
+func (b *Builder) Exit() {
+	opcode := instruction.NewJumpOpcode(instruction.BPF_JMP, instruction.BPF_EXIT, instruction.BPF_K)
+	b.Raw(instruction.Encode(opcode, 0, 0, 0))
+}
+
+// Build resolves the label references and returns the program. The distances
+// are counted in instruction slots so 64-bit immediate loads count twice.
+func (b *Builder) Build() (*Program, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	prog := NewProgram()
+	prog.Instructions = append(prog.Instructions, b.prog.Instructions...)
+	for _, ref := range b.references {
+		target, ok := b.labels[ref.label]
+		if !ok {
+			return nil, fmt.Errorf("label %q is not defined", ref.label)
+		}
+		ins := &prog.Instructions[ref.index]
+		distance := target - (ins.Number + 1)
+		if ref.imm {
+			ins.Instruction.SetImm(instruction.Imm(distance))
+			continue
+		}
+		if distance < math.MinInt16 || distance > math.MaxInt16 {
+			return nil, fmt.Errorf("label %q is too far to jump to from instruction %d", ref.label, ins.Number)
+		}
+		ins.Instruction.SetOffset(instruction.Offset(distance))
+	}
+	return &prog, nil
+}
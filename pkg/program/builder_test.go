@@ -0,0 +1,130 @@
+package program
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+func TestBuilder(t *testing.T) {
+	// the program from the README
+	want := []uint64{
+		0xb701000000000000,
+		0x631afcff00000000,
+		0x850000000e000000,
+		0xbf06000000000000,
+		0x636af8ff00000000,
+		0xbfa2000000000000,
+		0x07020000fcffffff,
+		0x1801000000000000,
+		0x0000000000000000,
+		0x8500000001000000,
+		0x5500090000000000,
+		0xbfa2000000000000,
+		0x07020000fcffffff,
+		0xbfa3000000000000,
+		0x07030000f8ffffff,
+		0x1801000000000000,
+		0x0000000000000000,
+		0xb704000000000000,
+		0x8500000002000000,
+		0x0500010000000000,
+		0x6360000000000000,
+		0xb700000000000000,
+		0x9500000000000000,
+	}
+	data := make([]byte, 0, len(want)*8)
+	for _, word := range want {
+		data = binary.BigEndian.AppendUint64(data, word)
+	}
+	wantProg, err := parseBytes(data, 8, binary.LittleEndian, func(data []byte, index, width int) (uint64, error) {
+		return binary.BigEndian.Uint64(data[index : index+width]), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBuilder()
+	b.Mov64Imm(instruction.BPF_R1, 0)
+	b.StoreMem(instruction.BPF_W, instruction.BPF_R10, -4, instruction.BPF_R1)
+	b.Call(14)
+	b.Mov64Reg(instruction.BPF_R6, instruction.BPF_R0)
+	b.StoreMem(instruction.BPF_W, instruction.BPF_R10, -8, instruction.BPF_R6)
+	b.Mov64Reg(instruction.BPF_R2, instruction.BPF_R10)
+	b.ALU64Imm(instruction.BPF_ADD, instruction.BPF_R2, -4)
+	b.LoadImm64(instruction.BPF_R1, 0)
+	b.Call(1)
+	b.JNE(instruction.BPF_R0, 0, "update")
+	b.Mov64Reg(instruction.BPF_R2, instruction.BPF_R10)
+	b.ALU64Imm(instruction.BPF_ADD, instruction.BPF_R2, -4)
+	b.Mov64Reg(instruction.BPF_R3, instruction.BPF_R10)
+	b.ALU64Imm(instruction.BPF_ADD, instruction.BPF_R3, -8)
+	b.LoadImm64(instruction.BPF_R1, 0)
+	b.Mov64Imm(instruction.BPF_R4, 0)
+	b.Call(2)
+	b.Ja("out")
+	b.Label("update")
+	b.StoreMem(instruction.BPF_W, instruction.BPF_R0, 0, instruction.BPF_R6)
+	b.Label("out")
+	b.Mov64Imm(instruction.BPF_R0, 0)
+	b.Exit()
+
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(prog.Instructions) != len(wantProg.Instructions) {
+		t.Fatalf("Build() got %d instructions, want %d", len(prog.Instructions), len(wantProg.Instructions))
+	}
+	for i := range prog.Instructions {
+		if prog.Instructions[i] != wantProg.Instructions[i] {
+			t.Errorf("instruction %d = %+v, want %+v", i, prog.Instructions[i], wantProg.Instructions[i])
+		}
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	b := NewBuilder()
+	b.Ja("nowhere")
+	if _, err := b.Build(); err == nil {
+		t.Error("Build() expected an error on an undefined label")
+	}
+
+	b = NewBuilder()
+	b.Label("twice")
+	b.Exit()
+	b.Label("twice")
+	if _, err := b.Build(); err == nil {
+		t.Error("Build() expected an error on a label defined twice")
+	}
+}
+
+func TestBuilderBackwardJump(t *testing.T) {
+	b := NewBuilder()
+	b.Label("loop")
+	b.LoadImm64(instruction.BPF_R1, 1)
+	b.ALU64Imm(instruction.BPF_SUB, instruction.BPF_R1, 1)
+	b.JNE(instruction.BPF_R1, 0, "loop")
+	b.CallLocal("loop")
+	b.Jump32Imm(instruction.BPF_JGT, instruction.BPF_R1, 1, "loop")
+	b.Gotol("loop")
+	b.Exit()
+
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if got := prog.Instructions[2].Instruction.Offset(); got != -4 {
+		t.Errorf("jump offset = %d, want -4", got)
+	}
+	if got := prog.Instructions[3].Instruction.Imm(); got != -5 {
+		t.Errorf("call imm = %d, want -5", got)
+	}
+	if got := prog.Instructions[4].Instruction.Opcode().Class(); got != instruction.BPF_JMP32 {
+		t.Errorf("32-bit jump class = %s, want BPF_JMP32", got)
+	}
+	if got := prog.Instructions[5].Instruction.Imm(); got != -7 {
+		t.Errorf("long jump imm = %d, want -7", got)
+	}
+}
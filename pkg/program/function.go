@@ -0,0 +1,162 @@
+package program
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Function is a part of an ELF section described by a function symbol, the
+// main program of the section or one of its subprograms.
+type Function struct {
+	// Name is the symbol name, it is empty for the instructions that are not
+	// covered by any function symbol
+	Name string
+	// Offset and Size locate the function in the section, in bytes
+	Offset  uint64
+	Size    uint64
+	Program *Program
+}
+
+// functionSymbols returns the function symbols of the section sorted by offset
+func functionSymbols(file *elf.File, sec *elf.Section) ([]elf.Symbol, error) {
+	symbols, err := file.Symbols()
+	if errors.Is(err, elf.ErrNoSymbols) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var funcs []elf.Symbol
+	for _, sym := range symbols {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || int(sym.Section) >= len(file.Sections) {
+			continue
+		}
+		if file.Sections[sym.Section] == sec {
+			funcs = append(funcs, sym)
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].Value < funcs[j].Value
+	})
+	return funcs, nil
+}
+
+// FunctionsFromELF splits the program of the section into its functions,
+// instruction numbers stay relative to the start of the section.
+func FunctionsFromELF(path string, section string) ([]Function, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return functionsFromSection(file, section)
+}
+
+// LoadFunctions is FunctionsFromELF for an ELF file read from r
+func LoadFunctions(r io.ReaderAt, section string) ([]Function, error) {
+	file, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return functionsFromSection(file, section)
+}
+
+// ProgramSectionsFromELF splits every program section of the ELF file into
+// its functions
+func ProgramSectionsFromELF(path string) ([]ProgramSection, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return programSections(file)
+}
+
+// LoadProgramSections is ProgramSectionsFromELF for an ELF file read from r
+func LoadProgramSections(r io.ReaderAt) ([]ProgramSection, error) {
+	file, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return programSections(file)
+}
+
+func programSections(file *elf.File) ([]ProgramSection, error) {
+	var sections []ProgramSection
+	for _, sec := range file.Sections {
+		if !isProgramSection(sec) {
+			continue
+		}
+		functions, err := functionsFromSection(file, sec.Name)
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", sec.Name, err)
+		}
+		sections = append(sections, ProgramSection{Name: sec.Name, Functions: functions})
+	}
+	return sections, nil
+}
+
+func functionsFromSection(file *elf.File, section string) ([]Function, error) {
+	prog, sec, err := fromELFSection(file, section)
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := functionSymbols(file, sec)
+	if err != nil {
+		return nil, err
+	}
+
+	// symbolAt returns the function symbol covering the offset, nil if none
+	symbolAt := func(offset uint64) *elf.Symbol {
+		for i := range symbols {
+			if offset >= symbols[i].Value && offset < symbols[i].Value+symbols[i].Size {
+				return &symbols[i]
+			}
+		}
+		return nil
+	}
+
+	var functions []Function
+	var current *elf.Symbol
+	for _, ins := range prog.Instructions {
+		offset := uint64(ins.Number) * 8
+		sym := symbolAt(offset)
+		if len(functions) == 0 || sym != current {
+			current = sym
+			fn := Function{Offset: offset, Program: &Program{Helpers: prog.Helpers, Syntax: prog.Syntax}}
+			if sym != nil {
+				fn.Name = sym.Name
+			}
+			functions = append(functions, fn)
+		}
+		fn := &functions[len(functions)-1]
+		fn.Program.Instructions = append(fn.Program.Instructions, ins)
+		fn.Size = offset + insSize(ins) - fn.Offset
+	}
+	return functions, nil
+}
+
+func insSize(ins ProgramInstruction) uint64 {
+	if ins.Instruction.Extended64 {
+		return 16
+	}
+	return 8
+}
+
+// FindFunction returns the function with the given name
+func FindFunction(functions []Function, name string) (Function, error) {
+	names := make([]string, 0, len(functions))
+	for _, fn := range functions {
+		if fn.Name == name {
+			return fn, nil
+		}
+		if fn.Name != "" {
+			names = append(names, fn.Name)
+		}
+	}
+	return Function{}, fmt.Errorf("function %q not found, available functions: %v", name, names)
+}
@@ -0,0 +1,58 @@
+package program
+
+import "testing"
+
+func TestFunctionsFromELF(t *testing.T) {
+	functions, err := FunctionsFromELF("testdata/funcs.o", "kprobe/pizza")
+	if err != nil {
+		t.Fatalf("FunctionsFromELF() error = %v", err)
+	}
+
+	want := []struct {
+		name         string
+		offset, size uint64
+		numbers      []int
+	}{
+		{name: "pizza", offset: 0, size: 32, numbers: []int{0, 1, 2, 3}},
+		{name: "topping", offset: 32, size: 16, numbers: []int{4, 5}},
+	}
+	if len(functions) != len(want) {
+		t.Fatalf("FunctionsFromELF() got %d functions, want %d", len(functions), len(want))
+	}
+	for i, w := range want {
+		fn := functions[i]
+		if fn.Name != w.name || fn.Offset != w.offset || fn.Size != w.size {
+			t.Errorf("function %d = %s at %d of size %d, want %s at %d of size %d", i, fn.Name, fn.Offset, fn.Size, w.name, w.offset, w.size)
+		}
+		if len(fn.Program.Instructions) != len(w.numbers) {
+			t.Errorf("function %s has %d instructions, want %d", fn.Name, len(fn.Program.Instructions), len(w.numbers))
+			continue
+		}
+		for j, ins := range fn.Program.Instructions {
+			if ins.Number != w.numbers[j] {
+				t.Errorf("function %s instruction %d has number %d, want %d", fn.Name, j, ins.Number, w.numbers[j])
+			}
+		}
+	}
+
+	if fn, err := FindFunction(functions, "topping"); err != nil || fn.Offset != 32 {
+		t.Errorf("FindFunction(topping) = %+v, %v", fn, err)
+	}
+	if _, err := FindFunction(functions, "crust"); err == nil {
+		t.Error("FindFunction(crust) expected an error, it lives in .text")
+	}
+}
+
+func TestFunctionsFromELFRelocations(t *testing.T) {
+	// the relocation fixture has a single function covering the section
+	functions, err := FunctionsFromELF("testdata/reloc.o", "kprobe/pizza")
+	if err != nil {
+		t.Fatalf("FunctionsFromELF() error = %v", err)
+	}
+	if len(functions) != 1 || functions[0].Name != "pizza" || functions[0].Size != 64 {
+		t.Fatalf("FunctionsFromELF() = %+v, want pizza of size 64", functions)
+	}
+	if functions[0].Program.Instructions[0].Relocation == nil {
+		t.Error("FunctionsFromELF() lost the relocations")
+	}
+}
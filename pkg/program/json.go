@@ -0,0 +1,101 @@
+package program
+
+import (
+	"encoding/json"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+type jsonRelocation struct {
+	Type    string `json:"type"`
+	Symbol  string `json:"symbol"`
+	Section string `json:"section,omitempty"`
+}
+
+// jsonInstruction is the JSON form of a disassembled instruction, with its
+// decoded fields
+type jsonInstruction struct {
+	Index int    `json:"index"`
+	Raw   string `json:"raw"`
+	Class string `json:"class"`
+	// Code is the operation of the arithmetic and jump instructions and the
+	// mode of the load and store instructions
+	Code   string `json:"code"`
+	Source string `json:"source,omitempty"`
+	Size   string `json:"size,omitempty"`
+	Dst    string `json:"dst"`
+	Src    string `json:"src"`
+	Offset int16  `json:"offset"`
+	Imm    int32  `json:"imm"`
+	Imm64  *int64 `json:"imm64,omitempty"`
+	// Target is the instruction number the jumps and local calls land on
+	Target      *int            `json:"target,omitempty"`
+	Disassembly string          `json:"disassembly"`
+	Annotations []string        `json:"annotations,omitempty"`
+	Relocation  *jsonRelocation `json:"relocation,omitempty"`
+}
+
+// MarshalJSON encodes the instruction with its decoded fields, for the tools
+// that post-process the disassembly
+func (d DisassembledProgram) MarshalJSON() ([]byte, error) {
+	ins := d.Instruction
+	out := jsonInstruction{
+		Index:       d.InsNumber,
+		Raw:         ins.String(),
+		Class:       ins.Opcode().Class().String(),
+		Dst:         ins.Regs().DstReg().String(),
+		Src:         ins.Regs().SrcReg().String(),
+		Offset:      int16(ins.Offset()),
+		Imm:         int32(ins.Imm()),
+		Disassembly: d.Disassembled,
+		Annotations: d.Annotations,
+	}
+
+	if code, err := ins.Opcode().Code(); err == nil {
+		out.Code = code.String()
+	}
+	switch typed, _ := ins.Opcode().ToTyped(); op := typed.(type) {
+	case instruction.ArithmeticOpcode:
+		out.Source = sourceName(op.Source())
+	case instruction.JumpOpcode:
+		out.Source = sourceName(op.Source())
+	case instruction.LoadAndStoreOpcode:
+		out.Size = sizeName(op.Size())
+	}
+
+	if ins.Extended64 {
+		imm64 := int64(ins.Imm64())
+		out.Imm64 = &imm64
+	}
+	if target, ok := d.Target(); ok {
+		out.Target = &target
+	}
+	if d.Relocation != nil {
+		out.Relocation = &jsonRelocation{
+			Type:    d.Relocation.Type.String(),
+			Symbol:  d.Relocation.Symbol,
+			Section: d.Relocation.Section,
+		}
+	}
+	return json.Marshal(out)
+}
+
+// sizeNames are the kernel names of the sizes, the String method of OpcodeSize
+// gives their C types instead
+var sizeNames = map[instruction.OpcodeSize]string{
+	instruction.BPF_W:  "BPF_W",
+	instruction.BPF_H:  "BPF_H",
+	instruction.BPF_B:  "BPF_B",
+	instruction.BPF_DW: "BPF_DW",
+}
+
+func sizeName(s instruction.OpcodeSize) string {
+	return sizeNames[s]
+}
+
+func sourceName(s instruction.OpcodeSource) string {
+	if s == instruction.BPF_X {
+		return "BPF_X"
+	}
+	return "BPF_K"
+}
@@ -0,0 +1,69 @@
+package program
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+func TestDisassembledProgramMarshalJSON(t *testing.T) {
+	prog := NewProgram()
+	ld := instruction.NewInstruction(0x1801000000000000)
+	ld.AddPseudoInstruction(0x0000000000000000)
+	for i, ins := range []instruction.Instruction{
+		ld,
+		instruction.NewInstruction(0x631afcff00000000),
+		instruction.NewInstruction(0xbfa2000000000000),
+		instruction.NewInstruction(0x07020000fcffffff),
+		instruction.NewInstruction(0x8500000001000000),
+		instruction.NewInstruction(0x5500f9ff00000000),
+	} {
+		number := i
+		if i > 0 {
+			number++
+		}
+		prog.Instructions = append(prog.Instructions, ProgramInstruction{Instruction: ins, Number: number})
+	}
+	prog.Instructions[0].Relocation = &Relocation{Type: R_BPF_64_64, Symbol: "events", Section: ".maps"}
+
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{
+			index: 0,
+			want:  `{"index":0,"raw":"1801000000000000 0000000000000000","class":"BPF_LD","code":"BPF_IMM","size":"BPF_DW","dst":"r1","src":"r0","offset":0,"imm":0,"imm64":0,"disassembly":"r1 = map_by_fd(events) ll","relocation":{"type":"R_BPF_64_64","symbol":"events","section":".maps"}}`,
+		},
+		{
+			index: 1,
+			want:  `{"index":2,"raw":"631afcff00000000","class":"BPF_STX","code":"BPF_MEM","size":"BPF_W","dst":"r10","src":"r1","offset":-4,"imm":0,"disassembly":"*(u32 *)(r10 - 4) = r1"}`,
+		},
+		{
+			index: 3,
+			want:  `{"index":4,"raw":"07020000fcffffff","class":"BPF_ALU64","code":"BPF_ADD","source":"BPF_K","dst":"r2","src":"r0","offset":0,"imm":-4,"disassembly":"r2 += -4"}`,
+		},
+		{
+			index: 4,
+			want:  `{"index":5,"raw":"8500000001000000","class":"BPF_JMP","code":"BPF_CALL","source":"BPF_K","dst":"r0","src":"r0","offset":0,"imm":1,"disassembly":"call bpf_map_lookup_elem#1(r1=events, r2=fp-4)","annotations":["r1=events","r2=fp-4"]}`,
+		},
+		{
+			index: 5,
+			want:  `{"index":6,"raw":"5500f9ff00000000","class":"BPF_JMP","code":"BPF_JNE","source":"BPF_K","dst":"r0","src":"r0","offset":-7,"imm":0,"target":0,"disassembly":"if r0 != 0 goto -7"}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(disassembled[tt.index])
+		if err != nil {
+			t.Fatalf("MarshalJSON() error = %v", err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("MarshalJSON() =\n%s\nwant\n%s", got, tt.want)
+		}
+	}
+}
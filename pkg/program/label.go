@@ -0,0 +1,52 @@
+package program
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// reLabel matches the label lines printed before the jump targets of the
+// listings, like LBB0_20:
+var reLabel = regexp.MustCompile(`^[A-Za-z_.][\w.]*:$`)
+
+// IsLabel reports whether the line of a listing is a label line, which does
+// not hold any instruction
+func IsLabel(line string) bool {
+	return reLabel.MatchString(strings.TrimSpace(line))
+}
+
+// Labels names the instructions that are jumped to or called in the functions
+// of a section, by instruction number. The jump targets are named like the
+// llvm basic blocks, LBB0_20 for instruction 20 of the first function, and the
+// called functions after their symbol when they have one. The targets out of
+// the functions are not named.
+func Labels(functions []Function) map[int]string {
+	labels := map[int]string{}
+	// calls land on the first instruction of the functions
+	named := map[int]string{}
+	exists := map[int]bool{}
+	for _, fn := range functions {
+		if fn.Name != "" {
+			named[int(fn.Offset/8)] = fn.Name
+		}
+		for _, ins := range fn.Program.Instructions {
+			exists[ins.Number] = true
+		}
+	}
+
+	for i, fn := range functions {
+		for _, ins := range fn.Program.Instructions {
+			target, ok := jumpTarget(ins)
+			if !ok || !exists[target] {
+				continue
+			}
+			if name, ok := named[target]; ok {
+				labels[target] = name
+			} else if _, ok := labels[target]; !ok {
+				labels[target] = fmt.Sprintf("LBB%d_%d", i, target)
+			}
+		}
+	}
+	return labels
+}
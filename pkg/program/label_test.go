@@ -0,0 +1,78 @@
+package program
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+func TestLabels(t *testing.T) {
+	b := NewBuilder()
+	b.Mov64Imm(instruction.BPF_R1, 3)
+	b.Label("loop")
+	b.ALU64Imm(instruction.BPF_SUB, instruction.BPF_R1, 1)
+	b.JEQ(instruction.BPF_R1, 0, "out")
+	b.Ja("loop")
+	b.Label("out")
+	b.CallLocal("loop")
+	b.Exit()
+	prog, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	got := Labels([]Function{{Program: prog}})
+	want := map[int]string{1: "LBB0_1", 4: "LBB0_4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Labels() = %v, want %v", got, want)
+	}
+
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+	wantTargets := []struct {
+		target int
+		ok     bool
+	}{{0, false}, {0, false}, {4, true}, {1, true}, {1, true}, {0, false}}
+	for i, w := range wantTargets {
+		if target, ok := disassembled[i].Target(); target != w.target || ok != w.ok {
+			t.Errorf("instruction %d Target() = %d, %t, want %d, %t", i, target, ok, w.target, w.ok)
+		}
+	}
+	if got := disassembled[3].Disassembled; got != "goto -3" {
+		t.Errorf("backward jump = %q, want goto -3", got)
+	}
+}
+
+func TestLabelsOutOfRange(t *testing.T) {
+	prog, err := ReadRaw(bytes.NewReader([]byte{
+		0x85, 0x10, 0x00, 0x00, 0xfd, 0xff, 0xff, 0xff,
+		0x05, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x05, 0x00, 0xfe, 0xff, 0x00, 0x00, 0x00, 0x00,
+		0x95, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}), binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("ReadRaw() error = %v", err)
+	}
+	// the call to -2 and the jump to 7 land out of the program
+	want := map[int]string{1: "LBB0_1"}
+	if got := Labels([]Function{{Program: prog}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Labels() = %v, want %v", got, want)
+	}
+}
+
+func TestLabelsFunctions(t *testing.T) {
+	functions, err := FunctionsFromELF("testdata/funcs.o", "kprobe/pizza")
+	if err != nil {
+		t.Fatalf("FunctionsFromELF() error = %v", err)
+	}
+	// the local call of pizza is named after the function it calls
+	want := map[int]string{4: "topping"}
+	if got := Labels(functions); !reflect.DeepEqual(got, want) {
+		t.Errorf("Labels() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,237 @@
+package program
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MapDefinition holds the attributes of a map, decoded from the legacy
+// struct bpf_map_def or from the BTF of the .maps section
+type MapDefinition struct {
+	Type       uint32
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	Flags      uint32
+}
+
+// Map is a map declared by the object
+type Map struct {
+	Name    string
+	Section string
+	// Definition is nil when it could not be decoded, for example for a
+	// .maps section without BTF or with BTF this reader does not understand
+	Definition *MapDefinition
+}
+
+// Global is a global variable, defined in a data section
+type Global struct {
+	Name    string
+	Section string
+	// Offset and Size locate the variable in its section, in bytes
+	Offset uint64
+	Size   uint64
+}
+
+// ProgramSection is an executable section and the functions it holds
+type ProgramSection struct {
+	Name      string
+	Functions []Function
+}
+
+// Object is a BPF ELF object with everything it carries
+type Object struct {
+	License string
+	// KernelVersion is the content of the version section, 0 when missing
+	KernelVersion uint32
+	Programs      []ProgramSection
+	Maps          []Map
+	Globals       []Global
+}
+
+func isDataSection(name string) bool {
+	for _, prefix := range []string{".data", ".rodata", ".bss"} {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyMapDefinitionSize is the size of the fields of struct bpf_map_def,
+// loaders accept larger definitions with trailing fields
+const legacyMapDefinitionSize = 20
+
+// ObjectFromELF reads a BPF object from the ELF file at path
+func ObjectFromELF(path string) (*Object, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return newObject(file)
+}
+
+// LoadObject reads a BPF object from an ELF file
+func LoadObject(r io.ReaderAt) (*Object, error) {
+	file, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return newObject(file)
+}
+
+func newObject(file *elf.File) (*Object, error) {
+	obj := &Object{}
+
+	if sec := file.Section("license"); sec != nil {
+		data, err := sec.Data()
+		if err != nil {
+			return nil, err
+		}
+		obj.License = string(bytes.TrimRight(data, "\x00"))
+	}
+	if sec := file.Section("version"); sec != nil {
+		data, err := sec.Data()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != 4 {
+			return nil, fmt.Errorf("version section has %d bytes, want 4", len(data))
+		}
+		obj.KernelVersion = file.ByteOrder.Uint32(data)
+	}
+
+	programs, err := programSections(file)
+	if err != nil {
+		return nil, err
+	}
+	obj.Programs = programs
+
+	symbols, err := file.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, err
+	}
+
+	// the BTF only completes the definitions of the .maps section, the maps
+	// are still listed without them when it cannot be read, like when it
+	// holds kinds newer than this reader
+	btfMaps, err := btfMapDefinitions(file)
+	if err != nil {
+		btfMaps = nil
+	}
+
+	// list the maps and globals in the order of the file rather than of the
+	// symbol table
+	sort.SliceStable(symbols, func(i, j int) bool {
+		if symbols[i].Section != symbols[j].Section {
+			return symbols[i].Section < symbols[j].Section
+		}
+		return symbols[i].Value < symbols[j].Value
+	})
+	for _, sym := range symbols {
+		if elf.ST_TYPE(sym.Info) != elf.STT_OBJECT || int(sym.Section) >= len(file.Sections) {
+			continue
+		}
+		sec := file.Sections[sym.Section]
+		switch {
+		case sec.Name == ".maps":
+			m := Map{Name: sym.Name, Section: sec.Name}
+			if def, ok := btfMaps[sym.Name]; ok {
+				m.Definition = &def
+			}
+			obj.Maps = append(obj.Maps, m)
+		case sec.Name == "maps" || strings.HasPrefix(sec.Name, "maps/"):
+			def, err := legacyMapDefinition(file, sec, sym)
+			if err != nil {
+				return nil, fmt.Errorf("map %s: %w", sym.Name, err)
+			}
+			obj.Maps = append(obj.Maps, Map{Name: sym.Name, Section: sec.Name, Definition: def})
+		case isDataSection(sec.Name):
+			obj.Globals = append(obj.Globals, Global{
+				Name:    sym.Name,
+				Section: sec.Name,
+				Offset:  sym.Value,
+				Size:    sym.Size,
+			})
+		}
+	}
+	return obj, nil
+}
+
+func legacyMapDefinition(file *elf.File, sec *elf.Section, sym elf.Symbol) (*MapDefinition, error) {
+	data, err := sec.Data()
+	if err != nil {
+		return nil, err
+	}
+	if sym.Value+legacyMapDefinitionSize > uint64(len(data)) {
+		return nil, fmt.Errorf("definition at offset %d is out of the %s section", sym.Value, sec.Name)
+	}
+	def := data[sym.Value:]
+	return &MapDefinition{
+		Type:       file.ByteOrder.Uint32(def),
+		KeySize:    file.ByteOrder.Uint32(def[4:]),
+		ValueSize:  file.ByteOrder.Uint32(def[8:]),
+		MaxEntries: file.ByteOrder.Uint32(def[12:]),
+		Flags:      file.ByteOrder.Uint32(def[16:]),
+	}, nil
+}
+
+func btfMapDefinitions(file *elf.File) (map[string]MapDefinition, error) {
+	sec := file.Section(".BTF")
+	if sec == nil {
+		return nil, nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil, err
+	}
+	types, err := parseBTF(data, file.ByteOrder)
+	if err != nil {
+		return nil, err
+	}
+	return types.mapDefinitions(), nil
+}
+
+// ObjectRelocation is a relocation of a program section along with the
+// instruction it patches
+type ObjectRelocation struct {
+	Section string
+	// InsNumber is the number of the patched instruction in its section
+	InsNumber int
+	Relocation
+}
+
+// Relocations returns the relocations of every program section, in the order
+// of the sections and of their instructions
+func (o *Object) Relocations() []ObjectRelocation {
+	var relocations []ObjectRelocation
+	for _, sec := range o.Programs {
+		for _, fn := range sec.Functions {
+			for _, ins := range fn.Program.Instructions {
+				if ins.Relocation != nil {
+					relocations = append(relocations, ObjectRelocation{
+						Section:    sec.Name,
+						InsNumber:  ins.Number,
+						Relocation: *ins.Relocation,
+					})
+				}
+			}
+		}
+	}
+	return relocations
+}
+
+// Function returns the function with the given name, from any section
+func (o *Object) Function(name string) (Function, error) {
+	var all []Function
+	for _, sec := range o.Programs {
+		all = append(all, sec.Functions...)
+	}
+	return FindFunction(all, name)
+}
@@ -0,0 +1,171 @@
+package program
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func checkObject(t *testing.T, obj *Object) {
+	t.Helper()
+
+	if obj.License != "GPL" {
+		t.Errorf("License = %q, want GPL", obj.License)
+	}
+	if obj.KernelVersion != 330752 {
+		t.Errorf("KernelVersion = %d, want 330752", obj.KernelVersion)
+	}
+
+	var sections []string
+	for _, sec := range obj.Programs {
+		sections = append(sections, sec.Name)
+	}
+	if want := []string{"kprobe/pizza", "xdp"}; !reflect.DeepEqual(sections, want) {
+		t.Errorf("Programs = %v, want %v", sections, want)
+	}
+
+	wantMaps := []Map{
+		{Name: "events", Section: ".maps", Definition: &MapDefinition{Type: 1, KeySize: 4, ValueSize: 8, MaxEntries: 128}},
+		{Name: "legacy", Section: "maps", Definition: &MapDefinition{Type: 2, KeySize: 4, ValueSize: 8, MaxEntries: 16}},
+	}
+	if !reflect.DeepEqual(obj.Maps, wantMaps) {
+		t.Errorf("Maps = %+v, want %+v", obj.Maps, wantMaps)
+	}
+
+	wantGlobals := []Global{
+		{Name: "greeting", Section: ".rodata", Size: 6},
+		{Name: "ratio", Section: ".data", Size: 4},
+		{Name: "counter", Section: ".bss", Size: 8},
+	}
+	if !reflect.DeepEqual(obj.Globals, wantGlobals) {
+		t.Errorf("Globals = %+v, want %+v", obj.Globals, wantGlobals)
+	}
+
+	fn, err := obj.Function("pizza")
+	if err != nil {
+		t.Fatalf("Function(pizza) error = %v", err)
+	}
+	var relocated []string
+	for _, ins := range fn.Program.Instructions {
+		if ins.Relocation != nil {
+			relocated = append(relocated, ins.Relocation.Symbol)
+		}
+	}
+	if want := []string{"events", "legacy", "counter"}; !reflect.DeepEqual(relocated, want) {
+		t.Errorf("pizza relocations = %v, want %v", relocated, want)
+	}
+	if _, err := obj.Function("pasta"); err != nil {
+		t.Errorf("Function(pasta) error = %v", err)
+	}
+
+	wantRelocations := []ObjectRelocation{
+		{Section: "kprobe/pizza", InsNumber: 0, Relocation: Relocation{Type: R_BPF_64_64, Symbol: "events", Section: ".maps"}},
+		{Section: "kprobe/pizza", InsNumber: 2, Relocation: Relocation{Type: R_BPF_64_64, Symbol: "legacy", Section: "maps"}},
+		{Section: "kprobe/pizza", InsNumber: 4, Relocation: Relocation{Type: R_BPF_64_64, Symbol: "counter", Section: ".bss"}},
+	}
+	if got := obj.Relocations(); !reflect.DeepEqual(got, wantRelocations) {
+		t.Errorf("Relocations() = %+v, want %+v", got, wantRelocations)
+	}
+}
+
+func TestObjectFromELF(t *testing.T) {
+	obj, err := ObjectFromELF("testdata/object.o")
+	if err != nil {
+		t.Fatalf("ObjectFromELF() error = %v", err)
+	}
+	checkObject(t, obj)
+}
+
+func TestLoadObject(t *testing.T) {
+	f, err := os.Open("testdata/object.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	obj, err := LoadObject(f)
+	if err != nil {
+		t.Fatalf("LoadObject() error = %v", err)
+	}
+	checkObject(t, obj)
+}
+
+func TestObjectWithoutBTF(t *testing.T) {
+	// the relocation fixture declares events in .maps but carries no BTF
+	obj, err := ObjectFromELF("testdata/reloc.o")
+	if err != nil {
+		t.Fatalf("ObjectFromELF() error = %v", err)
+	}
+	for _, m := range obj.Maps {
+		if m.Name == "events" && m.Definition != nil {
+			t.Errorf("events Definition = %+v, want nil", m.Definition)
+		}
+	}
+}
+
+func TestObjectWithUnknownBTFKind(t *testing.T) {
+	data, err := os.ReadFile("testdata/object.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// turn the first BTF type, after the header, into a kind from the future
+	data[file.Section(".BTF").Offset+24+7] = 0x1f
+
+	obj, err := LoadObject(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("LoadObject() error = %v", err)
+	}
+	wantMaps := []Map{
+		{Name: "events", Section: ".maps"},
+		{Name: "legacy", Section: "maps", Definition: &MapDefinition{Type: 2, KeySize: 4, ValueSize: 8, MaxEntries: 16}},
+	}
+	if !reflect.DeepEqual(obj.Maps, wantMaps) {
+		t.Errorf("Maps = %+v, want %+v", obj.Maps, wantMaps)
+	}
+}
+
+func TestMapDefinitionsWithoutKeySize(t *testing.T) {
+	types := btfTypes{
+		{},
+		{name: "int", kind: btfKindInt, sizeOrType: 4},
+		{name: "opaque", kind: btfKindFwd},
+		{kind: btfKindPtr, sizeOrType: 2},
+		{kind: btfKindPtr, sizeOrType: 1},
+		{kind: btfKindArray, elemType: 1, nelems: 16},
+		{kind: btfKindPtr, sizeOrType: 5},
+		{kind: btfKindStruct, members: []btfMember{{name: "key", typeID: 3}}},
+		{name: "broken", kind: btfKindVar, sizeOrType: 7},
+		{kind: btfKindStruct, members: []btfMember{{name: "key", typeID: 4}, {name: "max_entries", typeID: 6}}},
+		{name: "counts", kind: btfKindVar, sizeOrType: 9},
+		{name: ".maps", kind: btfKindDatasec, members: []btfMember{{typeID: 8}, {typeID: 10}}},
+	}
+	want := map[string]MapDefinition{"counts": {KeySize: 4, MaxEntries: 16}}
+	if got := types.mapDefinitions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mapDefinitions() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseBTFErrors(t *testing.T) {
+	truncated := make([]byte, 24+8)
+	binary.LittleEndian.PutUint16(truncated, btfMagic)
+	binary.LittleEndian.PutUint32(truncated[4:], 24)
+	binary.LittleEndian.PutUint32(truncated[12:], 8)
+
+	for name, data := range map[string][]byte{
+		"short":           {0x9f, 0xeb},
+		"bad magic":       make([]byte, 24),
+		"truncated type":  truncated,
+		"types too large": append([]byte{0x9f, 0xeb, 1, 0, 24, 0, 0, 0, 0, 0, 0, 0, 0xff}, make([]byte, 11)...),
+	} {
+		if _, err := parseBTF(data, binary.LittleEndian); err == nil {
+			t.Errorf("parseBTF(%s) expected an error", name)
+		}
+	}
+}
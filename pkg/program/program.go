@@ -1,24 +1,34 @@
 package program
 
 import (
-	"bytes"
 	"debug/elf"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math/bits"
 	"os"
-	"strconv"
+	"strings"
 
+	"github.com/mtardy/mahebpf/pkg/helper"
 	"github.com/mtardy/mahebpf/pkg/instruction"
 )
 
 type ProgramInstruction struct {
 	Instruction instruction.Instruction
 	Number      int
+	// Relocation is set for the instructions patched by an ELF relocation
+	Relocation *Relocation
 }
 
 type Program struct {
 	Instructions []ProgramInstruction
+	// Helpers names the helper calls when disassembling, the default table is
+	// used when it is nil
+	Helpers *helper.Table
+	// Syntax renders the instructions when disassembling, the llvm-objdump
+	// one is used when it is nil
+	Syntax instruction.Syntax
 }
 
 func NewProgram() Program {
@@ -29,35 +39,140 @@ type DisassembledProgram struct {
 	InsNumber    int
 	Instruction  instruction.Instruction
 	Disassembled string
+	// Annotations are the values of the argument registers of the helper
+	// calls, like r2=fp-4, they are also part of Disassembled
+	Annotations []string
+	Relocation  *Relocation
+	// Decoded is the operation and the operands of the instruction, it is
+	// zero for the instructions that could not be decoded
+	Decoded instruction.Decoded
 }
 
-func (p Program) Disassemble() []DisassembledProgram {
+// Target returns the instruction number the jump or the local call lands on
+func (d DisassembledProgram) Target() (int, bool) {
+	return target(d.InsNumber, d.Decoded)
+}
+
+// disassemble decodes the instruction and sets the index of decode errors to
+// the instruction number, relocated instructions are rendered with their target
+func (ins ProgramInstruction) disassemble(opts instruction.DisassembleOptions) (instruction.Decoded, string, error) {
+	decoded, err := ins.Instruction.Decode()
+	if err != nil {
+		var decodeErr *instruction.DecodeError
+		if errors.As(err, &decodeErr) {
+			decodeErr.Index = ins.Number
+		}
+		return instruction.Decoded{}, "", err
+	}
+	if relocated, ok := relocatedImm(ins, decoded, opts.Syntax); ok {
+		return decoded, relocated, nil
+	}
+	return decoded, opts.Syntax.Format(decoded, opts), nil
+}
+
+// rawDirective renders the instruction slots as data directives, for the
+// instructions that cannot be decoded. Like the .8byte directive of the
+// assemblers, the values are words stored in the byte order of the program:
+// 0x00000000000001e7 for the bytes e7 01 00 00 00 00 00 00 of a little-endian
+// program and 0xe701000000000000 for the same bytes of a big-endian one.
+func rawDirective(ins instruction.Instruction) string {
+	word := bits.ReverseBytes64
+	if ins.BigEndian {
+		word = func(w uint64) uint64 { return w }
+	}
+	words := []string{fmt.Sprintf("0x%016x", word(ins.Basic))}
+	if ins.Extended64 {
+		words = append(words, fmt.Sprintf("0x%016x", word(ins.Pseudo)))
+	}
+	return ".8byte " + strings.Join(words, ", ")
+}
+
+func (p Program) disassembleOptions() instruction.DisassembleOptions {
+	opts := instruction.DisassembleOptions{Helpers: p.Helpers, Syntax: p.Syntax}
+	if opts.Helpers == nil {
+		opts.Helpers = helper.Default()
+	}
+	if opts.Syntax == nil {
+		opts.Syntax = instruction.LLVMSyntax
+	}
+	return opts
+}
+
+// Disassemble decodes the program and stops at the first instruction that
+// cannot be decoded. Helper calls are annotated with the values of their
+// argument registers, like call bpf_map_lookup_elem#1(r1=map, r2=fp-4).
+func (p Program) Disassemble() ([]DisassembledProgram, error) {
+	opts := p.disassembleOptions()
+	annotator := p.newCallAnnotator(opts)
 	out := []DisassembledProgram{}
 	for _, ins := range p.Instructions {
+		decoded, disassembled, err := ins.disassemble(opts)
+		if err != nil {
+			return nil, err
+		}
+		disassembled, annotations := annotator.annotate(ins, decoded, disassembled)
 		out = append(out, DisassembledProgram{
 			InsNumber:    ins.Number,
 			Instruction:  ins.Instruction,
-			Disassembled: ins.Instruction.Disassemble(),
+			Disassembled: disassembled,
+			Annotations:  annotations,
+			Relocation:   ins.Relocation,
+			Decoded:      decoded,
 		})
 	}
-	return out
+	return out, nil
 }
 
-func parseBytes(data []byte, width int, parser func(data []byte, index, width int) (uint64, error)) (*Program, error) {
+// DisassembleTolerant decodes the whole program, the instructions that cannot
+// be decoded are rendered as .8byte directives and their errors are joined in
+// the returned error.
+func (p Program) DisassembleTolerant() ([]DisassembledProgram, error) {
+	opts := p.disassembleOptions()
+	annotator := p.newCallAnnotator(opts)
+	out := []DisassembledProgram{}
+	var errs []error
+	for _, ins := range p.Instructions {
+		decoded, disassembled, err := ins.disassemble(opts)
+		var annotations []string
+		if err != nil {
+			errs = append(errs, err)
+			disassembled = rawDirective(ins.Instruction)
+			annotator.skip()
+		} else {
+			disassembled, annotations = annotator.annotate(ins, decoded, disassembled)
+		}
+		out = append(out, DisassembledProgram{
+			InsNumber:    ins.Number,
+			Instruction:  ins.Instruction,
+			Disassembled: disassembled,
+			Annotations:  annotations,
+			Relocation:   ins.Relocation,
+			Decoded:      decoded,
+		})
+	}
+	return out, errors.Join(errs...)
+}
+
+// parseBytes splits data in instruction slots of width, parser returns the
+// bytes of a slot in memory order, read as a big-endian word. A last 64-bit
+// immediate load without its second slot is kept alone, it fails to decode.
+func parseBytes(data []byte, width int, order binary.ByteOrder, parser func(data []byte, index, width int) (uint64, error)) (*Program, error) {
+	newInstruction := instruction.NewInstruction
+	if order == binary.BigEndian {
+		newInstruction = instruction.NewBigEndianInstruction
+	}
+
 	prog := NewProgram()
 	for i, j := 0, 0; i+width <= len(data); i, j = i+width, j+1 {
 		parsedInstruction, err := parser(data, i, width)
 		if err != nil {
 			return nil, err
 		}
-		ins := instruction.NewInstruction(parsedInstruction)
+		ins := newInstruction(parsedInstruction)
 		instructionNumber := j
-		if ins.NeedPseudoInstruction() {
+		if ins.NeedPseudoInstruction() && i+2*width <= len(data) {
 			i = i + width
 			j++
-			if i+width >= len(data) {
-				return nil, fmt.Errorf("ins 0x%016x needs a pseudo instruction and it's not available", ins.Basic)
-			}
 			pseudoIns, err := parser(data, i, width)
 			if err != nil {
 				return nil, err
@@ -72,10 +187,37 @@ func parseBytes(data []byte, width int, parser func(data []byte, index, width in
 	return &prog, nil
 }
 
-func listELFSections(file *elf.File) []string {
+// programSectionPrefixes are the section names programs are attached from,
+// for the objects that do not flag their program sections as executable
+var programSectionPrefixes = []string{
+	"action", "cgroup", "cgroup_skb", "classifier", "fentry", "fexit", "flow_dissector",
+	"fmod_ret", "freplace", "iter", "kprobe", "kretprobe", "ksyscall", "kretsyscall",
+	"lirc_mode2", "lsm", "lwt_in", "lwt_out", "lwt_seg6local", "lwt_xmit", "netfilter",
+	"perf_event", "raw_tp", "raw_tracepoint", "sk_lookup", "sk_msg", "sk_reuseport",
+	"sk_skb", "sockops", "socket", "struct_ops", "syscall", "tc", "tcx", "tp", "tp_btf",
+	"tracepoint", "uprobe", "uretprobe", "usdt", "xdp",
+}
+
+// isProgramSection reports whether the section holds instructions
+func isProgramSection(sec *elf.Section) bool {
+	if sec.Type != elf.SHT_PROGBITS || sec.Size == 0 {
+		return false
+	}
+	if sec.Flags&elf.SHF_EXECINSTR != 0 {
+		return true
+	}
+	for _, prefix := range programSectionPrefixes {
+		if sec.Name == prefix || strings.HasPrefix(sec.Name, prefix+"/") || strings.HasPrefix(sec.Name, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func listELFSections(file *elf.File, programsOnly bool) []string {
 	sections := make([]string, 0, len(file.Sections))
 	for _, sec := range file.Sections {
-		if sec.Name == "" {
+		if sec.Name == "" || programsOnly && !isProgramSection(sec) {
 			continue
 		}
 		sections = append(sections, sec.Name)
@@ -83,12 +225,15 @@ func listELFSections(file *elf.File) []string {
 	return sections
 }
 
-func ListELFSections(path string) ([]string, error) {
+// ListELFSections returns the names of the sections of the ELF file, only the
+// ones holding programs if programsOnly is set
+func ListELFSections(path string, programsOnly bool) ([]string, error) {
 	file, err := elf.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	return listELFSections(file), nil
+	defer file.Close()
+	return listELFSections(file, programsOnly), nil
 }
 
 func FromELF(path string, section string) (*Program, error) {
@@ -96,40 +241,118 @@ func FromELF(path string, section string) (*Program, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
+	prog, _, err := fromELFSection(file, section)
+	return prog, err
+}
+
+// fromELFSection reads the program of the section with its relocations
+func fromELFSection(file *elf.File, section string) (*Program, *elf.Section, error) {
 	sec := file.Section(section)
 
 	if sec == nil {
-		return nil, fmt.Errorf("section not found, available sections: %v", listELFSections(file))
+		return nil, nil, fmt.Errorf("section not found, available sections: %v", listELFSections(file, false))
 	}
 
 	byteCode, err := sec.Data()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(byteCode)%8 != 0 {
-		return nil, errors.New("section program len is not a multiple of 8")
+		return nil, nil, errors.New("section program len is not a multiple of 8")
 	}
 
-	return parseBytes(byteCode, 8, func(data []byte, index, width int) (uint64, error) {
+	prog, err := parseBytes(byteCode, 8, file.ByteOrder, func(data []byte, index, width int) (uint64, error) {
 		return binary.BigEndian.Uint64(data[index : index+width]), nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := applyRelocations(file, sec, prog); err != nil {
+		return nil, nil, err
+	}
+	return prog, sec, nil
 }
 
+// FromASCII reads a little-endian program written in hexadecimal
 func FromASCII(path string) (*Program, error) {
-	text, err := os.ReadFile(path)
+	return FromASCIIWithByteOrder(path, binary.LittleEndian)
+}
+
+// FromASCIIWithByteOrder reads a program written in hexadecimal, for the
+// programs of the given byte order
+func FromASCIIWithByteOrder(path string, order binary.ByteOrder) (*Program, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	text = bytes.ReplaceAll(text, []byte(" "), []byte(""))
-	text = bytes.ReplaceAll(text, []byte("\n"), []byte(""))
+	defer file.Close()
+	return ReadASCII(file, order)
+}
+
+// ReadASCII reads a program written in hexadecimal, for the programs of the
+// given byte order. See decodeASCII for the accepted formats.
+func ReadASCII(r io.Reader, order binary.ByteOrder) (*Program, error) {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeASCII(text)
+	if err != nil {
+		return nil, err
+	}
+	return parseBytes(data, 8, order, func(data []byte, index, width int) (uint64, error) {
+		return binary.BigEndian.Uint64(data[index : index+width]), nil
+	})
+}
 
-	if len(text)%16 != 0 {
-		return nil, errors.New("text len is not a multiple of 16")
+// FromRaw reads a program from a flat binary file of instructions, like the
+// ones dumped by bpftool or extracted from an ELF section
+func FromRaw(path string, order binary.ByteOrder) (*Program, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
+	return ReadRaw(file, order)
+}
 
-	return parseBytes(text, 16, func(data []byte, index, width int) (uint64, error) {
-		return strconv.ParseUint(string(text[index:index+width]), width, 64)
+// ReadRaw reads a program from flat binary instructions of the given byte
+// order
+func ReadRaw(r io.Reader, order binary.ByteOrder) (*Program, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%8 != 0 {
+		return nil, errors.New("raw program len is not a multiple of 8")
+	}
+	return parseBytes(data, 8, order, func(data []byte, index, width int) (uint64, error) {
+		return binary.BigEndian.Uint64(data[index : index+width]), nil
 	})
 }
+
+func writeASCIIWord(w io.Writer, word uint64) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], word)
+	_, err := fmt.Fprintf(w, "% x\n", b)
+	return err
+}
+
+// WriteASCII writes the program bytecode in the text format read by
+// FromASCII, one instruction slot per line.
+func (p Program) WriteASCII(w io.Writer) error {
+	for _, ins := range p.Instructions {
+		if err := writeASCIIWord(w, ins.Instruction.Basic); err != nil {
+			return err
+		}
+		if ins.Instruction.Extended64 {
+			if err := writeASCIIWord(w, ins.Instruction.Pseudo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
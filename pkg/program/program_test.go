@@ -1,6 +1,13 @@
 package program
 
 import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/mtardy/mahebpf/pkg/instruction"
@@ -159,3 +166,269 @@ func BenchmarkDisassemble(b *testing.B) {
 		prog.Disassemble()
 	}
 }
+
+func TestWriteASCII(t *testing.T) {
+	prog := NewProgram()
+	for _, raw := range []uint64{0xb701000000000000, 0x1801000000000000, 0x9500000000000000} {
+		ins := instruction.NewInstruction(raw)
+		if ins.NeedPseudoInstruction() {
+			ins.AddPseudoInstruction(0x00000000ffffffff)
+		}
+		prog.Instructions = append(prog.Instructions, ProgramInstruction{Instruction: ins})
+	}
+
+	path := filepath.Join(t.TempDir(), "prog.txt")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.WriteASCII(file); err != nil {
+		t.Fatalf("WriteASCII() error = %v", err)
+	}
+	file.Close()
+
+	got, err := FromASCII(path)
+	if err != nil {
+		t.Fatalf("FromASCII() error = %v", err)
+	}
+	if len(got.Instructions) != len(prog.Instructions) {
+		t.Fatalf("FromASCII() got %d instructions, want %d", len(got.Instructions), len(prog.Instructions))
+	}
+	for i := range got.Instructions {
+		if got.Instructions[i].Instruction != prog.Instructions[i].Instruction {
+			t.Errorf("instruction %d = %v, want %v", i, got.Instructions[i].Instruction, prog.Instructions[i].Instruction)
+		}
+	}
+}
+
+func TestDisassembleTolerant(t *testing.T) {
+	prog := NewProgram()
+	for i, raw := range []uint64{0xb701000000000000, 0xe701000000000000, 0x9500000000000000} {
+		prog.Instructions = append(prog.Instructions, ProgramInstruction{
+			Instruction: instruction.NewInstruction(raw),
+			Number:      i,
+		})
+	}
+
+	_, err := prog.Disassemble()
+	var decodeErr *instruction.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("Disassemble() error = %v, want a DecodeError", err)
+	}
+	if decodeErr.Index != 1 || decodeErr.Raw != 0xe701000000000000 {
+		t.Errorf("DecodeError = %+v, want index 1 and raw 0xe701000000000000", decodeErr)
+	}
+
+	disassembled, err := prog.DisassembleTolerant()
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("DisassembleTolerant() error = %v, want a DecodeError", err)
+	}
+	want := []string{"r1 = 0", ".8byte 0x00000000000001e7", "exit"}
+	if len(disassembled) != len(want) {
+		t.Fatalf("DisassembleTolerant() got %d instructions, want %d", len(disassembled), len(want))
+	}
+	for i := range want {
+		if disassembled[i].Disassembled != want[i] {
+			t.Errorf("instruction %d = %q, want %q", i, disassembled[i].Disassembled, want[i])
+		}
+	}
+}
+
+func TestDisassembleTolerantMissingPseudo(t *testing.T) {
+	prog, err := ReadRaw(bytes.NewReader([]byte{
+		0xb7, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x18, 0x01, 0x00, 0x00, 0x2a, 0x00, 0x00, 0x00,
+	}), binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("ReadRaw() error = %v", err)
+	}
+
+	disassembled, err := prog.DisassembleTolerant()
+	var decodeErr *instruction.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("DisassembleTolerant() error = %v, want a DecodeError", err)
+	}
+	if decodeErr.Index != 1 {
+		t.Errorf("DecodeError index = %d, want 1", decodeErr.Index)
+	}
+	want := []string{"r1 = 0", ".8byte 0x0000002a00000118"}
+	if len(disassembled) != len(want) {
+		t.Fatalf("DisassembleTolerant() got %d instructions, want %d", len(disassembled), len(want))
+	}
+	for i := range want {
+		if disassembled[i].Disassembled != want[i] {
+			t.Errorf("instruction %d = %q, want %q", i, disassembled[i].Disassembled, want[i])
+		}
+	}
+}
+
+func TestListELFSections(t *testing.T) {
+	all, err := ListELFSections("testdata/object.o", false)
+	if err != nil {
+		t.Fatalf("ListELFSections() error = %v", err)
+	}
+	if len(all) != 14 {
+		t.Errorf("ListELFSections() = %v, want the 14 named sections", all)
+	}
+
+	programs, err := ListELFSections("testdata/object.o", true)
+	if err != nil {
+		t.Fatalf("ListELFSections() error = %v", err)
+	}
+	if want := []string{"kprobe/pizza", "xdp"}; !reflect.DeepEqual(programs, want) {
+		t.Errorf("ListELFSections() = %v, want %v", programs, want)
+	}
+}
+
+func TestIsProgramSection(t *testing.T) {
+	section := func(name string, flags elf.SectionFlag, size uint64) *elf.Section {
+		return &elf.Section{SectionHeader: elf.SectionHeader{Name: name, Type: elf.SHT_PROGBITS, Flags: flags, Size: size}}
+	}
+	for _, tt := range []struct {
+		sec  *elf.Section
+		want bool
+	}{
+		{section("kprobe/pizza", elf.SHF_ALLOC|elf.SHF_EXECINSTR, 8), true},
+		{section(".text", elf.SHF_ALLOC|elf.SHF_EXECINSTR, 8), true},
+		{section(".text", elf.SHF_ALLOC|elf.SHF_EXECINSTR, 0), false},
+		// not flagged as executable but named after a program type
+		{section("kprobe/pizza", elf.SHF_ALLOC, 8), true},
+		{section("xdp", elf.SHF_ALLOC, 8), true},
+		{section("kprobe.multi/pizza", elf.SHF_ALLOC, 8), true},
+		{section("xdpizza", elf.SHF_ALLOC, 8), false},
+		{section(".rodata", elf.SHF_ALLOC, 8), false},
+		{&elf.Section{SectionHeader: elf.SectionHeader{Name: ".BTF", Type: elf.SHT_PROGBITS, Size: 8}}, false},
+		{&elf.Section{SectionHeader: elf.SectionHeader{Name: ".relxdp", Type: elf.SHT_REL, Size: 16}}, false},
+	} {
+		if got := isProgramSection(tt.sec); got != tt.want {
+			t.Errorf("isProgramSection(%s, %v) = %v, want %v", tt.sec.Name, tt.sec.Flags, got, tt.want)
+		}
+	}
+}
+
+func TestProgramSectionsFromELF(t *testing.T) {
+	sections, err := ProgramSectionsFromELF("testdata/funcs.o")
+	if err != nil {
+		t.Fatalf("ProgramSectionsFromELF() error = %v", err)
+	}
+	got := map[string][]string{}
+	for _, sec := range sections {
+		for _, fn := range sec.Functions {
+			got[sec.Name] = append(got[sec.Name], fn.Name)
+		}
+	}
+	want := map[string][]string{".text": {"crust"}, "kprobe/pizza": {"pizza", "topping"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProgramSectionsFromELF() = %v, want %v", got, want)
+	}
+}
+
+func TestFromELFBigEndian(t *testing.T) {
+	// the same source assembled for bpfel and bpfeb
+	disassemble := func(path string) []string {
+		t.Helper()
+		prog, err := FromELF(path, "kprobe/pizza")
+		if err != nil {
+			t.Fatalf("FromELF(%s) error = %v", path, err)
+		}
+		disassembled, err := prog.Disassemble()
+		if err != nil {
+			t.Fatalf("Disassemble(%s) error = %v", path, err)
+		}
+		var out []string
+		for _, ins := range disassembled {
+			out = append(out, ins.Disassembled)
+		}
+		return out
+	}
+
+	little, big := disassemble("testdata/endian_el.o"), disassemble("testdata/endian_eb.o")
+	if !reflect.DeepEqual(little, big) {
+		t.Errorf("bpfeb disassembled to %q, want %q", big, little)
+	}
+	if want := "r1 = map_by_fd(events) ll"; len(big) < 5 || big[4] != want {
+		t.Errorf("bpfeb relocated load = %q, want %q", big, want)
+	}
+}
+
+func TestFromASCIIWithByteOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prog.txt")
+	if err := os.WriteFile(path, []byte("07 20 00 00 ff ff ff fc\n95 00 00 00 00 00 00 00\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := FromASCIIWithByteOrder(path, binary.BigEndian)
+	if err != nil {
+		t.Fatalf("FromASCIIWithByteOrder() error = %v", err)
+	}
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+	if got := disassembled[0].Disassembled; got != "r2 += -4" {
+		t.Errorf("got %q, want %q", got, "r2 += -4")
+	}
+
+	// undecodable instructions keep their byte order in the directives
+	if got := rawDirective(instruction.NewBigEndianInstruction(0xe701000000000000)); got != ".8byte 0xe701000000000000" {
+		t.Errorf("rawDirective() = %q", got)
+	}
+}
+
+func TestReadRaw(t *testing.T) {
+	raw := []byte{
+		0x18, 0x01, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x95, 0, 0, 0, 0, 0, 0, 0,
+	}
+	prog, err := ReadRaw(bytes.NewReader(raw), binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("ReadRaw() error = %v", err)
+	}
+	if len(prog.Instructions) != 2 || prog.Instructions[1].Number != 2 {
+		t.Fatalf("ReadRaw() = %+v, want the ld_imm64 and the exit at 2", prog.Instructions)
+	}
+	if imm := prog.Instructions[0].Instruction.Imm64(); imm != 0xffffffff {
+		t.Errorf("ReadRaw() imm64 = 0x%x, want 0xffffffff", imm)
+	}
+
+	path := filepath.Join(t.TempDir(), "prog.bin")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := FromRaw(path, binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("FromRaw() error = %v", err)
+	}
+	if !reflect.DeepEqual(fromFile, prog) {
+		t.Errorf("FromRaw() = %+v, want %+v", fromFile, prog)
+	}
+
+	if _, err := ReadRaw(bytes.NewReader(raw[:12]), binary.LittleEndian); err == nil {
+		t.Error("ReadRaw() expected an error for a truncated program")
+	}
+}
+
+func TestLoadFunctions(t *testing.T) {
+	data, err := os.ReadFile("testdata/funcs.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	functions, err := LoadFunctions(bytes.NewReader(data), "kprobe/pizza")
+	if err != nil {
+		t.Fatalf("LoadFunctions() error = %v", err)
+	}
+	want, err := FunctionsFromELF("testdata/funcs.o", "kprobe/pizza")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(functions, want) {
+		t.Errorf("LoadFunctions() = %+v, want %+v", functions, want)
+	}
+
+	sections, err := LoadProgramSections(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("LoadProgramSections() error = %v", err)
+	}
+	if len(sections) != 2 {
+		t.Errorf("LoadProgramSections() got %d sections, want 2", len(sections))
+	}
+}
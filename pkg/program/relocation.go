@@ -0,0 +1,124 @@
+package program
+
+import (
+	"debug/elf"
+	"fmt"
+	"strings"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+// RelocationType is the type of the BPF ELF relocations, the elf package
+// does not define them.
+type RelocationType uint32
+
+const (
+	R_BPF_NONE        RelocationType = 0
+	R_BPF_64_64       RelocationType = 1
+	R_BPF_64_ABS64    RelocationType = 2
+	R_BPF_64_ABS32    RelocationType = 3
+	R_BPF_64_NODYLD32 RelocationType = 4
+	R_BPF_64_32       RelocationType = 10
+)
+
+func (t RelocationType) String() string {
+	switch t {
+	case R_BPF_NONE:
+		return "R_BPF_NONE"
+	case R_BPF_64_64:
+		return "R_BPF_64_64"
+	case R_BPF_64_ABS64:
+		return "R_BPF_64_ABS64"
+	case R_BPF_64_ABS32:
+		return "R_BPF_64_ABS32"
+	case R_BPF_64_NODYLD32:
+		return "R_BPF_64_NODYLD32"
+	case R_BPF_64_32:
+		return "R_BPF_64_32"
+	default:
+		return fmt.Sprintf("RelocationType(%d)", uint32(t))
+	}
+}
+
+// Relocation is the ELF relocation applied to an instruction, it names the
+// map, global variable or section the instruction refers to.
+type Relocation struct {
+	Type RelocationType
+	// Symbol is the name of the target symbol, the name of the section for
+	// section symbols
+	Symbol string
+	// Section is the name of the section the symbol is defined in
+	Section string
+}
+
+// IsMap reports whether the relocation targets a map definition
+func (r Relocation) IsMap() bool {
+	return r.Section == "maps" || r.Section == ".maps" || strings.HasPrefix(r.Section, "maps/")
+}
+
+// relocatedImm renders the 64-bit immediate load of a relocated instruction
+// with its target symbol, the imm holds the offset from the symbol.
+func relocatedImm(ins ProgramInstruction, decoded instruction.Decoded, syntax instruction.Syntax) (string, bool) {
+	if ins.Relocation == nil || decoded.Kind != instruction.KindLoadImm64 || decoded.Operands[1].Pseudo != instruction.BPF_IMM0 {
+		return "", false
+	}
+	return syntax.FormatSymbol(decoded, ins.Relocation.Symbol, ins.Relocation.IsMap()), true
+}
+
+// applyRelocations attaches the entries of the .rel<section> section to the
+// instructions they patch, BPF objects only use relocations without addends.
+// The entries that do not point to an instruction or to a symbol are skipped,
+// the instructions are disassembled without them.
+func applyRelocations(file *elf.File, section *elf.Section, prog *Program) error {
+	rel := file.Section(".rel" + section.Name)
+	if rel == nil || rel.Type != elf.SHT_REL {
+		return nil
+	}
+
+	data, err := rel.Data()
+	if err != nil {
+		return err
+	}
+	symbols, err := file.Symbols()
+	if err != nil {
+		return fmt.Errorf("failed to read the symbols for %s: %w", rel.Name, err)
+	}
+
+	byOffset := make(map[uint64]int, len(prog.Instructions))
+	for i, ins := range prog.Instructions {
+		byOffset[uint64(ins.Number)*8] = i
+	}
+
+	const entrySize = 16
+	if len(data)%entrySize != 0 {
+		return fmt.Errorf("section %s len is not a multiple of %d", rel.Name, entrySize)
+	}
+	for i := 0; i < len(data); i += entrySize {
+		offset := file.ByteOrder.Uint64(data[i:])
+		info := file.ByteOrder.Uint64(data[i+8:])
+
+		index, ok := byOffset[offset]
+		if !ok {
+			continue
+		}
+		// the symbols returned by the elf package skip the null symbol
+		symIndex := elf.R_SYM64(info)
+		if symIndex == 0 || int(symIndex) > len(symbols) {
+			continue
+		}
+		sym := symbols[symIndex-1]
+
+		reloc := &Relocation{
+			Type:   RelocationType(elf.R_TYPE64(info)),
+			Symbol: sym.Name,
+		}
+		if int(sym.Section) < len(file.Sections) {
+			reloc.Section = file.Sections[sym.Section].Name
+		}
+		if elf.ST_TYPE(sym.Info) == elf.STT_SECTION {
+			reloc.Symbol = reloc.Section
+		}
+		prog.Instructions[index].Relocation = reloc
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package program
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"testing"
+
+	"github.com/mtardy/mahebpf/pkg/instruction"
+)
+
+func TestFromELFRelocations(t *testing.T) {
+	prog, err := FromELF("testdata/reloc.o", "kprobe/pizza")
+	if err != nil {
+		t.Fatalf("FromELF() error = %v", err)
+	}
+
+	reloc := prog.Instructions[0].Relocation
+	if reloc == nil || reloc.Symbol != "events" || reloc.Section != ".maps" || reloc.Type != R_BPF_64_64 {
+		t.Errorf("instruction 0 relocation = %+v, want events in .maps", reloc)
+	}
+	if reloc := prog.Instructions[1].Relocation; reloc == nil || reloc.Symbol != ".rodata" {
+		t.Errorf("instruction 2 relocation = %+v, want the .rodata section symbol", reloc)
+	}
+	if reloc := prog.Instructions[3].Relocation; reloc != nil {
+		t.Errorf("instruction 6 relocation = %+v, want none", reloc)
+	}
+
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+	want := []string{
+		"r1 = map_by_fd(events) ll",
+		"r2 = .rodata + 16 ll",
+		"r3 = counter ll",
+		"r0 = 0",
+		"exit",
+	}
+	if len(disassembled) != len(want) {
+		t.Fatalf("Disassemble() got %d instructions, want %d", len(disassembled), len(want))
+	}
+	for i := range want {
+		if disassembled[i].Disassembled != want[i] {
+			t.Errorf("instruction %d = %q, want %q", disassembled[i].InsNumber, disassembled[i].Disassembled, want[i])
+		}
+	}
+}
+
+func TestRelocationsOutOfInstructions(t *testing.T) {
+	data, err := os.ReadFile("testdata/reloc.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// move the first relocation to the middle of its instruction
+	rel := file.Section(".relkprobe/pizza")
+	file.ByteOrder.PutUint64(data[rel.Offset:], 4)
+
+	functions, err := LoadFunctions(bytes.NewReader(data), "kprobe/pizza")
+	if err != nil {
+		t.Fatalf("LoadFunctions() error = %v", err)
+	}
+	prog := functions[0].Program
+	if reloc := prog.Instructions[0].Relocation; reloc != nil {
+		t.Errorf("instruction 0 relocation = %+v, want none", reloc)
+	}
+	if reloc := prog.Instructions[1].Relocation; reloc == nil || reloc.Symbol != ".rodata" {
+		t.Errorf("instruction 2 relocation = %+v, want the .rodata section symbol", reloc)
+	}
+}
+
+func TestFromELFRelocationsSyntax(t *testing.T) {
+	prog, err := FromELF("testdata/reloc.o", "kprobe/pizza")
+	if err != nil {
+		t.Fatalf("FromELF() error = %v", err)
+	}
+	prog.Syntax = instruction.BpftoolSyntax
+
+	disassembled, err := prog.Disassemble()
+	if err != nil {
+		t.Fatalf("Disassemble() error = %v", err)
+	}
+	want := []string{
+		"(18) r1 = map[events]",
+		"(18) r2 = .rodata+16",
+		"(18) r3 = counter",
+		"(b7) r0 = 0",
+		"(95) exit",
+	}
+	if len(disassembled) != len(want) {
+		t.Fatalf("Disassemble() got %d instructions, want %d", len(disassembled), len(want))
+	}
+	for i := range want {
+		if disassembled[i].Disassembled != want[i] {
+			t.Errorf("instruction %d = %q, want %q", disassembled[i].InsNumber, disassembled[i].Disassembled, want[i])
+		}
+	}
+}
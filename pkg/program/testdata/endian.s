@@ -0,0 +1,26 @@
+# llvm-mc -triple bpfel -mcpu=v3 -filetype=obj endian.s -o endian_el.o
+# llvm-mc -triple bpfeb -mcpu=v3 -filetype=obj endian.s -o endian_eb.o
+	.section	kprobe/pizza,"ax",@progbits
+	.globl	pizza
+	.type	pizza,@function
+pizza:
+	r6 = r1
+	*(u32 *)(r10 - 4) = r6
+	r2 = r10
+	r2 += -4
+	r1 = events ll
+	call 1
+	if r0 == 0 goto +2
+	r1 = 0x1122334455667788 ll
+	w3 = *(u32 *)(r0 + 260)
+	r0 = 0
+	exit
+.Lfunc_end0:
+	.size	pizza, .Lfunc_end0-pizza
+
+	.section	.maps,"aw",@progbits
+	.globl	events
+	.type	events,@object
+events:
+	.zero	32
+	.size	events, 32
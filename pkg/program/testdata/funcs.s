@@ -0,0 +1,27 @@
+# llvm-mc -triple bpfel -mcpu=v3 -filetype=obj funcs.s -o funcs.o
+	.section	kprobe/pizza,"ax",@progbits
+	.globl	pizza
+	.type	pizza,@function
+pizza:
+	r6 = r1
+	call topping
+	r0 = 0
+	exit
+.Lfunc_end0:
+	.size	pizza, .Lfunc_end0-pizza
+
+	.type	topping,@function
+topping:
+	r0 = 1
+	exit
+.Lfunc_end1:
+	.size	topping, .Lfunc_end1-topping
+
+	.text
+	.globl	crust
+	.type	crust,@function
+crust:
+	r0 = 2
+	exit
+.Lfunc_end2:
+	.size	crust, .Lfunc_end2-crust
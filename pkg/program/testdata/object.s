@@ -0,0 +1,99 @@
+# llvm-mc -triple bpfel -mcpu=v3 -filetype=obj object.s -o object.o
+# the .BTF section is generated by hand, it only describes the events map
+	.section	kprobe/pizza,"ax",@progbits
+	.globl	pizza
+	.type	pizza,@function
+pizza:
+	r1 = events ll
+	r2 = legacy ll
+	r3 = counter ll
+	r0 = 0
+	exit
+.Lfunc_end0:
+	.size	pizza, .Lfunc_end0-pizza
+
+	.section	xdp,"ax",@progbits
+	.globl	pasta
+	.type	pasta,@function
+pasta:
+	r0 = 2
+	exit
+.Lfunc_end1:
+	.size	pasta, .Lfunc_end1-pasta
+
+	.section	.maps,"aw",@progbits
+	.globl	events
+	.type	events,@object
+events:
+	.zero	32
+	.size	events, 32
+
+	.section	maps,"aw",@progbits
+	.globl	legacy
+	.type	legacy,@object
+legacy:
+	.long	2
+	.long	4
+	.long	8
+	.long	16
+	.long	0
+	.size	legacy, 20
+
+	.section	.rodata,"a",@progbits
+	.globl	greeting
+	.type	greeting,@object
+greeting:
+	.asciz	"hello"
+	.size	greeting, 6
+
+	.section	.data,"aw",@progbits
+	.globl	ratio
+	.type	ratio,@object
+ratio:
+	.long	42
+	.size	ratio, 4
+
+	.section	.bss,"aw",@nobits
+	.globl	counter
+	.type	counter,@object
+counter:
+	.zero	8
+	.size	counter, 8
+
+	.section	license,"aw",@progbits
+	.globl	_license
+	.type	_license,@object
+_license:
+	.asciz	"GPL"
+	.size	_license, 4
+
+	.section	version,"aw",@progbits
+	.globl	_version
+	.type	_version,@object
+_version:
+	.long	330752
+	.size	_version, 4
+
+	.section	.BTF,"",@progbits
+	.byte	0x9f, 0xeb, 0x01, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf4, 0x00, 0x00, 0x00
+	.byte	0xf4, 0x00, 0x00, 0x00, 0x51, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01
+	.byte	0x04, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03
+	.byte	0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00
+	.byte	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00
+	.byte	0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00
+	.byte	0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x04, 0x00, 0x00, 0x00
+	.byte	0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00
+	.byte	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x06, 0x00, 0x00, 0x00, 0x12, 0x00, 0x00, 0x00
+	.byte	0x00, 0x00, 0x00, 0x01, 0x08, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00
+	.byte	0x00, 0x00, 0x00, 0x02, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x04
+	.byte	0x20, 0x00, 0x00, 0x00, 0x29, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00
+	.byte	0x2e, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x3a, 0x00, 0x00, 0x00
+	.byte	0x07, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x3e, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00
+	.byte	0xc0, 0x00, 0x00, 0x00, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0e, 0x0a, 0x00, 0x00, 0x00
+	.byte	0x01, 0x00, 0x00, 0x00, 0x4b, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x0f, 0x20, 0x00, 0x00, 0x00
+	.byte	0x0b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x69, 0x6e, 0x74
+	.byte	0x00, 0x75, 0x6e, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x20, 0x69, 0x6e, 0x74, 0x00, 0x6c, 0x6f
+	.byte	0x6e, 0x67, 0x20, 0x6c, 0x6f, 0x6e, 0x67, 0x20, 0x75, 0x6e, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64
+	.byte	0x20, 0x69, 0x6e, 0x74, 0x00, 0x74, 0x79, 0x70, 0x65, 0x00, 0x6d, 0x61, 0x78, 0x5f, 0x65, 0x6e
+	.byte	0x74, 0x72, 0x69, 0x65, 0x73, 0x00, 0x6b, 0x65, 0x79, 0x00, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x00
+	.byte	0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x00, 0x2e, 0x6d, 0x61, 0x70, 0x73, 0x00
@@ -0,0 +1,31 @@
+# llvm-mc -triple bpfel -mcpu=v3 -filetype=obj reloc.s -o reloc.o
+	.section	kprobe/pizza,"ax",@progbits
+	.globl	pizza
+	.type	pizza,@function
+pizza:
+	r1 = events ll
+	r2 = .L.str ll
+	r3 = counter ll
+	r0 = 0
+	exit
+.Lfunc_end0:
+	.size	pizza, .Lfunc_end0-pizza
+
+	.section	.maps,"aw",@progbits
+	.globl	events
+	.type	events,@object
+events:
+	.zero	24
+	.size	events, 24
+
+	.section	.rodata,"a",@progbits
+	.zero	16
+.L.str:
+	.asciz	"hello"
+
+	.section	.bss,"aw",@nobits
+	.globl	counter
+	.type	counter,@object
+counter:
+	.zero	8
+	.size	counter, 8